@@ -320,3 +320,37 @@ func TestNodeWithDataBetweenLinks(t *testing.T) {
 		t.Fatal("expected Decode to fail")
 	}
 }
+
+func TestDecodeCopyData(t *testing.T) {
+	src := mustEncode(t, NewNodeBuilder().SetData([]byte("data")).mustBuild(t))
+	decode := map[string]func(DecodeOptions, ipld.NodeAssembler, []byte) error{
+		"DecodeBytes": func(opts DecodeOptions, na ipld.NodeAssembler, src []byte) error {
+			return opts.DecodeBytes(na, src)
+		},
+		"Decode with Bytes": func(opts DecodeOptions, na ipld.NodeAssembler, src []byte) error {
+			return opts.Decode(na, bytes.NewBuffer(src))
+		},
+		"Decode with Read": func(opts DecodeOptions, na ipld.NodeAssembler, src []byte) error {
+			return opts.Decode(na, bytes.NewReader(src))
+		},
+	}
+	for name, decode := range decode {
+		for _, copyData := range []bool{false, true} {
+			buf := bytes.Clone(src)
+			nb := Type.PBNode.NewBuilder()
+			if err := decode(DecodeOptions{CopyData: copyData}, nb, buf); err != nil {
+				t.Fatal(err)
+			}
+			node := nb.Build().(PBNode)
+
+			// reuse the buffer, as for the next block
+			copy(buf, bytes.Repeat([]byte{'x'}, len(buf)))
+
+			data := string(node.FieldData().Must().Bytes())
+			aliased := !copyData && name != "Decode with Read"
+			if expected := map[bool]string{false: "data", true: "xxxx"}[aliased]; data != expected {
+				t.Fatalf("%s with CopyData %v: expected Data %q after reusing the buffer, got %q", name, copyData, expected, data)
+			}
+		}
+	}
+}
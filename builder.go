@@ -0,0 +1,144 @@
+package dagpb
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// NodeBuilder is a convenient way to build a PBNode from Go code, as an
+// alternative to assembling one with Type.PBNode.NewBuilder() or fluent.
+// Links are kept in the canonical sorted order as they are added, with links
+// of the same Name kept in the order they were added, so the PBNode produced
+// by Build encodes as-is. Each call is checked as it is made; the first
+// problem found stops any further changes and is returned by Build.
+//
+//	node, err := dagpb.NewNodeBuilder().
+//		SetData(data).
+//		AddLink("a", aCid, aSize).
+//		AddLink("b", bCid, bSize).
+//		Build()
+type NodeBuilder struct {
+	links   []_PBLink
+	data    []byte
+	hasData bool
+	err     error
+}
+
+// NewNodeBuilder returns an empty NodeBuilder. Building without adding
+// anything produces a PBNode with no links and no Data.
+func NewNodeBuilder() *NodeBuilder {
+	return &NodeBuilder{}
+}
+
+// SetData sets the Data of the PBNode, replacing any previously set. The
+// bytes are not copied. Note that an empty Data is present in the encoded
+// form, use ClearData to leave it out.
+func (b *NodeBuilder) SetData(data []byte) *NodeBuilder {
+	if b.err != nil {
+		return b
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	b.data = data
+	b.hasData = true
+	return b
+}
+
+// ClearData removes any Data set on the PBNode.
+func (b *NodeBuilder) ClearData() *NodeBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.data = nil
+	b.hasData = false
+	return b
+}
+
+// AddLink adds a link with all three of Hash, Name and Tsize set.
+func (b *NodeBuilder) AddLink(name string, c cid.Cid, tsize uint64) *NodeBuilder {
+	if b.err != nil {
+		return b
+	}
+	link, err := makePBLink(name, c, tsize)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.insert(link)
+	return b
+}
+
+// AddPBLink adds an existing PBLink, which allows for links without a Name
+// or Tsize.
+func (b *NodeBuilder) AddPBLink(link PBLink) *NodeBuilder {
+	if b.err != nil {
+		return b
+	}
+	if err := validatePBLink(link); err != nil {
+		b.err = err
+		return b
+	}
+	b.insert(*link)
+	return b
+}
+
+func (b *NodeBuilder) insert(link _PBLink) {
+	ii := sortedInsertIndex(b.links, link.sortName())
+	b.links = append(b.links, _PBLink{})
+	copy(b.links[ii+1:], b.links[ii:])
+	b.links[ii] = link
+}
+
+// Build returns the PBNode, or the first error found while building it. The
+// NodeBuilder is reset and may be used to build another PBNode.
+func (b *NodeBuilder) Build() (PBNode, error) {
+	if b.err != nil {
+		err := b.err
+		*b = NodeBuilder{}
+		return nil, err
+	}
+	links := b.links
+	if links == nil {
+		links = []_PBLink{}
+	}
+	node := newPBNode(links, b.data, b.hasData)
+	*b = NodeBuilder{}
+	return node, nil
+}
+
+// MakePBLink returns a PBLink with all three of Hash, Name and Tsize set.
+func MakePBLink(name string, c cid.Cid, tsize uint64) (PBLink, error) {
+	link, err := makePBLink(name, c, tsize)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func makePBLink(name string, c cid.Cid, tsize uint64) (_PBLink, error) {
+	if !c.Defined() {
+		return _PBLink{}, fmt.Errorf("invalid DAG-PB form (link must have a Hash)")
+	}
+	if tsize > math.MaxInt64 {
+		return _PBLink{}, fmt.Errorf("Link has out of range Tsize value [%v]", tsize)
+	}
+	return newPBLink(c, name, true, int64(tsize), true), nil
+}
+
+// validatePBLink checks that a PBLink will encode, as AppendEncode would.
+func validatePBLink(link PBLink) error {
+	if link == nil {
+		return fmt.Errorf("invalid DAG-PB form (link must not be nil)")
+	}
+	if cl, ok := link.Hash.x.(cidlink.Link); !ok || !cl.Cid.Defined() {
+		return fmt.Errorf("invalid DAG-PB form (link must have a Hash)")
+	}
+	if link.Tsize.Exists() && link.Tsize.v.x < 0 {
+		return fmt.Errorf("Link has negative Tsize value [%v]", link.Tsize.v.x)
+	}
+	return nil
+}
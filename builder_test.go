@@ -0,0 +1,108 @@
+package dagpb
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+func TestNodeBuilder(t *testing.T) {
+	c1 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	c2 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V")
+
+	// same as TestNodeWithTwoUnsortedLinks, links added out of order
+	b := NewNodeBuilder().
+		SetData([]byte("some data")).
+		AddLink("some other link", c2, 8).
+		AddLink("some link", c1, 100000000)
+	node, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := node.FieldLinks().Lookup(0).FieldName().Must().String(); name != "some link" {
+		t.Fatalf("links should be sorted as they're added, first is %q", name)
+	}
+	enc, err := AppendEncode(nil, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(enc) != "12340a2212208ab7a6c5e74737878ac73863cb76739d15d4666de44e5756bf55a2f9e9ab5f431209736f6d65206c696e6b1880c2d72f12370a2212208ab7a6c5e74737878ac73863cb76739d15d4666de44e5756bf55a2f9e9ab5f44120f736f6d65206f74686572206c696e6b18080a09736f6d652064617461" {
+		t.Fatalf("unexpected encoding: %x", enc)
+	}
+
+	// the builder is reset by Build
+	node, err = b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.FieldLinks().Length() != 0 || node.FieldData().Exists() {
+		t.Fatal("expected an empty node after Build")
+	}
+	if enc, err := AppendEncode(nil, node); err != nil || len(enc) != 0 {
+		t.Fatalf("expected an empty node to encode, got %x %v", enc, err)
+	}
+}
+
+func TestNodeBuilderStableOrder(t *testing.T) {
+	// links with the same Name stay in the order they're added, as
+	// AppendEncode's stable sort would keep them
+	cids := []cid.Cid{
+		mkcid(t, "QmUGhP2X8xo9dsj45vqx1H6i5WqPqLqmLQsHTTxd3ke8mp"),
+		mkcid(t, "QmP7SrR76KHK9A916RbHG1ufy2TzNABZgiE23PjZDMzZXy"),
+		mkcid(t, "QmQg1v4o9xdT3Q14wh4S7dxZkDjyZ9ssFzFzyep1YrVJBY"),
+	}
+	b := NewNodeBuilder().AddLink("b", cids[0], 1)
+	for _, c := range cids {
+		b.AddLink("", c, 2)
+	}
+	unnamed, err := MakePBLink("", cids[2], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unnamed.Name = _String__Maybe{m: schema.Maybe_Absent} // sorts as ""
+	b.AddPBLink(unnamed)
+	b.AddLink("a", cids[1], 4)
+	node, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	itr := node.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, l := itr.Next()
+		order = append(order, l.FieldHash().Link().String())
+		if l.FieldName().Exists() {
+			order[len(order)-1] += "/" + l.FieldName().Must().String()
+		}
+	}
+	expected := []string{
+		cids[0].String() + "/", cids[1].String() + "/", cids[2].String() + "/", cids[2].String(),
+		cids[1].String() + "/a", cids[0].String() + "/b",
+	}
+	if strings.Join(order, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected order:\n%v\nexpected:\n%v", order, expected)
+	}
+}
+
+func TestNodeBuilderErrors(t *testing.T) {
+	_, err := NewNodeBuilder().AddLink("a", cid.Undef, 1).AddLink("b", acid, 1).Build()
+	if err == nil || !strings.Contains(err.Error(), "link must have a Hash") {
+		t.Fatalf("expected an error for an undefined CID, got %v", err)
+	}
+	_, err = NewNodeBuilder().AddLink("a", acid, math.MaxUint64).Build()
+	if err == nil || !strings.Contains(err.Error(), "out of range Tsize") {
+		t.Fatalf("expected an error for a huge Tsize, got %v", err)
+	}
+	_, err = NewNodeBuilder().AddPBLink(nil).Build()
+	if err == nil {
+		t.Fatal("expected an error for a nil PBLink")
+	}
+	if _, err := MakePBLink("a", cid.Undef, 1); err == nil {
+		t.Fatal("expected an error for an undefined CID")
+	}
+}
@@ -0,0 +1,258 @@
+// Command dagpb inspects and converts DAG-PB blocks.
+//
+// Usage:
+//
+//	dagpb decode [-hex] [-format dag-json|tree] [file]
+//	dagpb encode [-hex] [file]
+//	dagpb validate [-hex] [file]
+//	dagpb cid [-hex] [-version 0|1] [-hash sha2-256] [file]
+//	dagpb dump [-hex] [file]
+//
+// Input is read from the named file, or from stdin when no file (or "-") is
+// given. Blocks are read and written as raw bytes unless -hex is given.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/printer"
+	"github.com/multiformats/go-multihash"
+)
+
+const usage = `usage: dagpb <command> [flags] [file]
+
+commands:
+  decode    print a DAG-PB block as dag-json or as a tree
+  encode    build a DAG-PB block from dag-json
+  validate  check that a block decodes strictly and is canonically encoded
+  cid       compute the CID of a block
+  dump      print an annotated wire-level listing of a block
+
+run 'dagpb <command> -h' for the flags of each command
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"decode":   decodeCmd,
+	"encode":   encodeCmd,
+	"validate": validateCmd,
+	"cid":      cidCmd,
+	"dump":     dumpCmd,
+}
+
+// errUsage is returned by commands after a usage problem has already been
+// reported by the flag package.
+var errUsage = errors.New("usage error")
+
+// run executes the command line in args and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "dagpb: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if err := cmd(args[1:], stdin, stdout, stderr); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "dagpb %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// parseFlags parses args into fs, which should have been created with
+// flag.ContinueOnError and will print its own usage messages.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(fs.Output(), "expected at most one input file, got %d\n", fs.NArg())
+		return errUsage
+	}
+	return nil
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("dagpb "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// readInput reads the file named by the first argument in fs, or stdin, and
+// hex decodes it if asked to.
+func readInput(fs *flag.FlagSet, stdin io.Reader, isHex bool) ([]byte, error) {
+	var byts []byte
+	var err error
+	if name := fs.Arg(0); name != "" && name != "-" {
+		byts, err = os.ReadFile(name)
+	} else {
+		byts, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isHex {
+		return hex.DecodeString(strings.TrimSpace(string(byts)))
+	}
+	return byts, nil
+}
+
+func decodeBlock(byts []byte) (dagpb.PBNode, error) {
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, byts); err != nil {
+		return nil, err
+	}
+	return nb.Build().(dagpb.PBNode), nil
+}
+
+func decodeCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decode", stderr)
+	isHex := fs.Bool("hex", false, "read the block as hex")
+	format := fs.String("format", "dag-json", "output format, dag-json or tree")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byts, err := readInput(fs, stdin, *isHex)
+	if err != nil {
+		return err
+	}
+	node, err := decodeBlock(byts)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "dag-json":
+		if err := dagjson.Encode(node.Representation(), stdout); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout)
+		return err
+	case "tree":
+		printer.Fprint(stdout, node)
+		_, err = fmt.Fprintln(stdout)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func encodeCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("encode", stderr)
+	isHex := fs.Bool("hex", false, "write the block as hex")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byts, err := readInput(fs, stdin, false)
+	if err != nil {
+		return err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(byts)); err != nil {
+		return err
+	}
+	enc, err := dagpb.AppendEncode(nil, nb.Build())
+	if err != nil {
+		return err
+	}
+	if *isHex {
+		_, err = fmt.Fprintln(stdout, hex.EncodeToString(enc))
+		return err
+	}
+	_, err = stdout.Write(enc)
+	return err
+}
+
+func validateCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("validate", stderr)
+	isHex := fs.Bool("hex", false, "read the block as hex")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byts, err := readInput(fs, stdin, *isHex)
+	if err != nil {
+		return err
+	}
+	node, err := decodeBlock(byts)
+	if err != nil {
+		return fmt.Errorf("strict decode failed: %w", err)
+	}
+	enc, err := dagpb.AppendEncode(nil, node)
+	if err != nil {
+		return fmt.Errorf("re-encode failed: %w", err)
+	}
+	if !bytes.Equal(enc, byts) {
+		return fmt.Errorf("block is not canonically encoded, %d bytes would re-encode as %d different bytes", len(byts), len(enc))
+	}
+	_, err = fmt.Fprintln(stdout, "ok")
+	return err
+}
+
+func cidCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("cid", stderr)
+	isHex := fs.Bool("hex", false, "read the block as hex")
+	version := fs.Uint64("version", 1, "CID version, 0 or 1")
+	hashName := fs.String("hash", "sha2-256", "multihash function name")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byts, err := readInput(fs, stdin, *isHex)
+	if err != nil {
+		return err
+	}
+	if _, err := decodeBlock(byts); err != nil {
+		return err
+	}
+
+	mhType, ok := multihash.Names[*hashName]
+	if !ok {
+		return fmt.Errorf("unknown hash function %q", *hashName)
+	}
+	if *version == 0 && mhType != multihash.SHA2_256 {
+		return fmt.Errorf("CIDv0 requires sha2-256, not %s", *hashName)
+	}
+	if *version > 1 {
+		return fmt.Errorf("unsupported CID version %d", *version)
+	}
+	prefix := cid.Prefix{Version: *version, Codec: cid.DagProtobuf, MhType: mhType, MhLength: -1}
+	c, err := prefix.Sum(byts)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, c)
+	return err
+}
+
+func dumpCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("dump", stderr)
+	isHex := fs.Bool("hex", false, "read the block as hex")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	byts, err := readInput(fs, stdin, *isHex)
+	if err != nil {
+		return err
+	}
+	return dagpb.Dump(stdout, byts)
+}
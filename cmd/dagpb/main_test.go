@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// a node with one named link and some Data, from the dagpb package tests
+const (
+	nodeHex  = "12160a090155000500010203041209736f6d65206e616d650a050001020304"
+	nodeJSON = `{"Data":{"/":{"bytes":"AAECAwQ"}},"Links":[{"Hash":{"/":"bafkqabiaaebagba"},"Name":"some name"}]}`
+)
+
+func runCmd(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestDecode(t *testing.T) {
+	stdout, stderr, code := runCmd(t, nodeHex, "decode", "-hex")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if strings.TrimSpace(stdout) != nodeJSON {
+		t.Fatalf("unexpected dag-json output: %s", stdout)
+	}
+
+	stdout, stderr, code = runCmd(t, nodeHex, "decode", "-hex", "-format", "tree")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "some name") {
+		t.Fatalf("unexpected tree output: %s", stdout)
+	}
+
+	if _, _, code := runCmd(t, "0a0500010203", "decode", "-hex"); code != 1 {
+		t.Fatalf("expected a truncated block to fail, got exit code %d", code)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	stdout, stderr, code := runCmd(t, nodeJSON, "encode", "-hex")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if strings.TrimSpace(stdout) != nodeHex {
+		t.Fatalf("unexpected encoded block: %s", stdout)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, stderr, code := runCmd(t, nodeHex, "validate", "-hex"); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	// Data before Links decodes, but isn't canonical
+	_, stderr, code := runCmd(t, "0a05000102030412160a090155000500010203041209736f6d65206e616d65", "validate", "-hex")
+	if code != 1 || !strings.Contains(stderr, "canonical") {
+		t.Fatalf("expected a canonical form failure, got exit code %d: %s", code, stderr)
+	}
+
+	_, stderr, code = runCmd(t, "1200", "validate", "-hex")
+	if code != 1 || !strings.Contains(stderr, "strict decode failed") {
+		t.Fatalf("expected a strict decode failure, got exit code %d: %s", code, stderr)
+	}
+}
+
+func TestCid(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+		code     int
+	}{
+		{[]string{"-version", "0"}, "QmRga2vSpjfgW4awPDFYBmAdNStPkNYXqkvdmYCLwPoJot", 0},
+		{[]string{"-version", "1"}, "bafybeibrvvr6pbjnw44u5dfdxph5k4dp46qcj6dmmhdinikpwkoumyfvsm", 0},
+		{[]string{"-version", "0", "-hash", "blake3"}, "", 1},
+		{[]string{"-hash", "nope"}, "", 1},
+	} {
+		stdout, stderr, code := runCmd(t, nodeHex, append([]string{"cid", "-hex"}, tc.args...)...)
+		if code != tc.code {
+			t.Fatalf("%v: exit code %d: %s", tc.args, code, stderr)
+		}
+		if strings.TrimSpace(stdout) != tc.expected {
+			t.Fatalf("%v: unexpected CID %s", tc.args, stdout)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	stdout, stderr, code := runCmd(t, nodeHex, "dump", "-hex")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "codec raw (0x55)") || !strings.Contains(stdout, `Name "some name"`) {
+		t.Fatalf("unexpected dump: %s", stdout)
+	}
+
+	// the listing is still printed for a malformed block
+	stdout, stderr, code = runCmd(t, "120b0a090155000500010203", "dump", "-hex")
+	if code != 1 || !strings.Contains(stderr, "at offset 2") {
+		t.Fatalf("expected a malformed block to fail, got exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "unparsed") {
+		t.Fatalf("unexpected dump: %s", stdout)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if _, _, code := runCmd(t, ""); code != 2 {
+		t.Fatalf("expected exit code 2 without a command, got %d", code)
+	}
+	if _, _, code := runCmd(t, "", "frobnicate"); code != 2 {
+		t.Fatalf("expected exit code 2 for an unknown command, got %d", code)
+	}
+	if _, _, code := runCmd(t, "", "decode", "-nope"); code != 2 {
+		t.Fatalf("expected exit code 2 for an unknown flag, got %d", code)
+	}
+}
@@ -0,0 +1,331 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// PathChange is a single difference found by DiffDAG. Op is one of
+// LinkAdded, LinkRemoved or LinkChanged, and Old or New is cid.Undef where
+// the path is only present on one side.
+type PathChange struct {
+	// Path is the link Names leading to the change, joined with "/". The
+	// roots themselves have an empty Path.
+	Path string
+	Op   LinkOp
+	Old  cid.Cid
+	New  cid.Cid
+}
+
+// String returns a short description of the change, such as
+// "docs/img/logo.png changed".
+func (c PathChange) String() string {
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	switch c.Op {
+	case LinkAdded:
+		return path + " added"
+	case LinkRemoved:
+		return path + " removed"
+	}
+	return path + " changed"
+}
+
+// DiffDAG compares the trees under two roots, loading blocks from lsys, and
+// calls fn with each path that differs. An error returned by fn stops the
+// walk and is returned by DiffDAG.
+//
+// The walk descends only where link Hashes differ, so any block reachable
+// from both roots by the same path is never loaded. Where a path is present
+// on only one side it is reported as added or removed, without descending
+// further.
+//
+// Blocks descended into are UnixFS directories, UnixFS HAMT sharded
+// directories, and plain DAG-PB nodes without UnixFS Data whose links all
+// have distinct, non-empty Names. Anything else, such as a UnixFS file or a
+// block of another codec, is reported as changed as a whole. A directory is
+// also reported as changed itself where none of its entries differ, for
+// example where only its Data or a Tsize has changed.
+//
+// Changes are reported in Name order, except within HAMT directories, where
+// they follow the order of the shard buckets.
+func DiffDAG(ctx context.Context, lsys *ipld.LinkSystem, a, b cid.Cid, fn func(PathChange) error) error {
+	if a.Equals(b) {
+		return nil
+	}
+	d := &dagDiffer{ctx: ctx, lsys: lsys, fn: fn}
+	return d.diff("", a, b)
+}
+
+type dagDiffer struct {
+	ctx      context.Context
+	lsys     *ipld.LinkSystem
+	fn       func(PathChange) error
+	reported int
+}
+
+// dagDir is a block that DiffDAG can descend into.
+type dagDir struct {
+	node   PBNode
+	hamt   bool
+	padLen int
+	fanout uint64
+}
+
+func (d *dagDiffer) report(c PathChange) error {
+	d.reported++
+	return d.fn(c)
+}
+
+func (d *dagDiffer) diff(path string, a, b cid.Cid) error {
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	aDir, err := d.loadDir(a)
+	if err != nil {
+		return err
+	}
+	var bDir *dagDir
+	if aDir != nil {
+		if bDir, err = d.loadDir(b); err != nil {
+			return err
+		}
+	}
+	if aDir == nil || bDir == nil {
+		return d.report(PathChange{Path: path, Op: LinkChanged, Old: a, New: b})
+	}
+
+	before := d.reported
+	if aDir.hamt && bDir.hamt && aDir.fanout == bDir.fanout {
+		err = d.diffShards(path, aDir.node, bDir.node, aDir.padLen)
+	} else {
+		var aEntries, bEntries map[string]cid.Cid
+		if aEntries, err = d.entries(aDir); err != nil {
+			return err
+		}
+		if bEntries, err = d.entries(bDir); err != nil {
+			return err
+		}
+		err = d.diffEntries(path, aEntries, bEntries)
+	}
+	if err != nil {
+		return err
+	}
+	if d.reported == before {
+		return d.report(PathChange{Path: path, Op: LinkChanged, Old: a, New: b})
+	}
+	return nil
+}
+
+func (d *dagDiffer) load(c cid.Cid) (PBNode, error) {
+	return loadPBNode(d.ctx, d.lsys, c)
+}
+
+func (d *dagDiffer) loadDir(c cid.Cid) (*dagDir, error) {
+	return loadDagDir(d.ctx, d.lsys, c)
+}
+
+func loadPBNode(ctx context.Context, lsys *ipld.LinkSystem, c cid.Cid) (PBNode, error) {
+	n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, Type.PBNode)
+	if err != nil {
+		return nil, err
+	}
+	return n.(PBNode), nil
+}
+
+// loadDagDir loads the block c, or returns nil if it isn't a directory.
+func loadDagDir(ctx context.Context, lsys *ipld.LinkSystem, c cid.Cid) (*dagDir, error) {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, nil
+	}
+	node, err := loadPBNode(ctx, lsys, c)
+	if err != nil {
+		return nil, err
+	}
+	if node.Data.Exists() {
+		ud, err := parseUnixFSData(node.Data.v.x)
+		if err != nil {
+			return nil, nil
+		}
+		switch ud.typ {
+		case unixfsDirectory:
+		case unixfsHAMTShard:
+			return &dagDir{node: node, hamt: true, padLen: hamtPadLength(ud.fanout), fanout: ud.fanout}, nil
+		default:
+			return nil, nil
+		}
+	}
+	seen := make(map[string]bool, len(node.Links.x))
+	for ii := range node.Links.x {
+		name := node.Links.x[ii].sortName()
+		if name == "" || seen[name] {
+			return nil, nil
+		}
+		seen[name] = true
+	}
+	return &dagDir{node: node}, nil
+}
+
+// entries returns the Hash of each entry of a directory by Name, loading
+// all of the shards of a HAMT.
+func (d *dagDiffer) entries(dir *dagDir) (map[string]cid.Cid, error) {
+	entries := make(map[string]cid.Cid, len(dir.node.Links.x))
+	if !dir.hamt {
+		for ii := range dir.node.Links.x {
+			l := &dir.node.Links.x[ii]
+			entries[l.sortName()] = linkCid(l)
+		}
+		return entries, nil
+	}
+	for ii := range dir.node.Links.x {
+		if err := d.shardEntries(&dir.node.Links.x[ii], dir.padLen, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// shardEntries adds the entries under a link of a HAMT shard, which is
+// either an entry itself or a link to a child shard.
+func (d *dagDiffer) shardEntries(l PBLink, padLen int, entries map[string]cid.Cid) error {
+	name := l.sortName()
+	if len(name) < padLen {
+		return fmt.Errorf("invalid UnixFS HAMT shard (link Name %q has no bucket prefix)", name)
+	}
+	if len(name) > padLen {
+		entries[name[padLen:]] = linkCid(l)
+		return nil
+	}
+	child, err := d.load(linkCid(l))
+	if err != nil {
+		return err
+	}
+	for ii := range child.Links.x {
+		if err := d.shardEntries(&child.Links.x[ii], padLen, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffShards compares two HAMT shards of the same fanout bucket by bucket,
+// descending into child shards only where they differ.
+func (d *dagDiffer) diffShards(path string, a, b PBNode, padLen int) error {
+	aBuckets, err := hamtBuckets(a, padLen)
+	if err != nil {
+		return err
+	}
+	bBuckets, err := hamtBuckets(b, padLen)
+	if err != nil {
+		return err
+	}
+	prefixes := make([]string, 0, len(aBuckets)+len(bBuckets))
+	for prefix := range aBuckets {
+		prefixes = append(prefixes, prefix)
+	}
+	for prefix := range bBuckets {
+		if _, ok := aBuckets[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		al, bl := aBuckets[prefix], bBuckets[prefix]
+		if al != nil && bl != nil && al.sortName() == bl.sortName() && pbLinkHashEqual(al, bl) {
+			continue
+		}
+		if al != nil && bl != nil && len(al.sortName()) == padLen && len(bl.sortName()) == padLen {
+			aChild, err := d.load(linkCid(al))
+			if err != nil {
+				return err
+			}
+			bChild, err := d.load(linkCid(bl))
+			if err != nil {
+				return err
+			}
+			if err := d.diffShards(path, aChild, bChild, padLen); err != nil {
+				return err
+			}
+			continue
+		}
+		aEntries, bEntries := make(map[string]cid.Cid), make(map[string]cid.Cid)
+		if al != nil {
+			if err := d.shardEntries(al, padLen, aEntries); err != nil {
+				return err
+			}
+		}
+		if bl != nil {
+			if err := d.shardEntries(bl, padLen, bEntries); err != nil {
+				return err
+			}
+		}
+		if err := d.diffEntries(path, aEntries, bEntries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hamtBuckets returns the links of a HAMT shard by bucket prefix.
+func hamtBuckets(node PBNode, padLen int) (map[string]PBLink, error) {
+	buckets := make(map[string]PBLink, len(node.Links.x))
+	for ii := range node.Links.x {
+		l := &node.Links.x[ii]
+		name := l.sortName()
+		if len(name) < padLen {
+			return nil, fmt.Errorf("invalid UnixFS HAMT shard (link Name %q has no bucket prefix)", name)
+		}
+		buckets[name[:padLen]] = l
+	}
+	return buckets, nil
+}
+
+func (d *dagDiffer) diffEntries(path string, a, b map[string]cid.Cid) error {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ac, aok := a[name]
+		bc, bok := b[name]
+		childPath := name
+		if path != "" {
+			childPath = path + "/" + name
+		}
+		var err error
+		switch {
+		case !bok:
+			err = d.report(PathChange{Path: childPath, Op: LinkRemoved, Old: ac})
+		case !aok:
+			err = d.report(PathChange{Path: childPath, Op: LinkAdded, New: bc})
+		case !ac.Equals(bc):
+			err = d.diff(childPath, ac, bc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func linkCid(l PBLink) cid.Cid {
+	if cl, ok := l.Hash.x.(cidlink.Link); ok {
+		return cl.Cid
+	}
+	return cid.Undef
+}
@@ -0,0 +1,142 @@
+package dagpb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+)
+
+var hamtData = []byte{0x08, 0x05, 0x28, 0x22, 0x30, 0x80, 0x02} // HAMTShard, murmur3-x64-64, fanout 256
+
+// recordLoads records the CIDs of the blocks loaded through lsys
+func recordLoads(lsys *ipld.LinkSystem) map[string]bool {
+	loaded := make(map[string]bool)
+	open := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		loaded[l.String()] = true
+		return open(lc, l)
+	}
+	return loaded
+}
+
+func diffDAG(t *testing.T, lsys *ipld.LinkSystem, a, b cid.Cid) string {
+	t.Helper()
+	var changes []string
+	err := DiffDAG(context.Background(), lsys, a, b, func(c PathChange) error {
+		changes = append(changes, c.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(changes, ", ")
+}
+
+func TestDiffDAG(t *testing.T) {
+	lsys, _ := mkLinkSystem()
+	dir := func(links ...pbLink) cid.Cid { return storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: links}) }
+
+	shared := dir(namedLink("x", storeRaw(t, lsys, []byte("x")), 1))
+	readme := storeRaw(t, lsys, []byte("readme"))
+	oldImg := dir(namedLink("logo.png", storeRaw(t, lsys, []byte("logo")), 4))
+	newImg := dir(namedLink("logo.png", storeRaw(t, lsys, []byte("logo2")), 5))
+	a := dir(
+		namedLink("docs", dir(namedLink("img", oldImg, 50), namedLink("readme", readme, 6)), 100),
+		namedLink("old", readme, 6),
+		namedLink("shared", shared, 50),
+	)
+	b := dir(
+		namedLink("docs", dir(namedLink("img", newImg, 51), namedLink("readme", readme, 6)), 101),
+		namedLink("new", readme, 6),
+		namedLink("shared", shared, 50),
+	)
+
+	loaded := recordLoads(lsys)
+	if changes := diffDAG(t, lsys, a, b); changes != "docs/img/logo.png changed, new added, old removed" {
+		t.Fatalf("unexpected changes: %s", changes)
+	}
+	if loaded[shared.String()] || loaded[readme.String()] {
+		t.Fatal("a shared block was loaded")
+	}
+
+	// identical roots load nothing
+	loaded = recordLoads(lsys)
+	if changes := diffDAG(t, lsys, a, a); changes != "" || len(loaded) != 0 {
+		t.Fatalf("expected no changes and no loads, got %q and %d loads", changes, len(loaded))
+	}
+
+	// a change only to the Tsize of an entry is reported on the directory
+	c := dir(namedLink("x", storeRaw(t, lsys, []byte("x")), 2))
+	if changes := diffDAG(t, lsys, shared, c); changes != "/ changed" {
+		t.Fatalf("unexpected changes: %s", changes)
+	}
+
+	// errors from the callback stop the walk
+	stop := errors.New("stop")
+	calls := 0
+	err := DiffDAG(context.Background(), lsys, a, b, func(PathChange) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expected the walk to stop after one change, got %v after %d", err, calls)
+	}
+}
+
+func TestDiffDAGHAMT(t *testing.T) {
+	lsys, _ := mkLinkSystem()
+	shard := func(links ...pbLink) cid.Cid { return storeNode(t, lsys, pbNode{data: hamtData, links: links}) }
+	file := func(s string) cid.Cid { return storeRaw(t, lsys, []byte(s)) }
+
+	sharedShard := shard(namedLink("10same", file("same"), 4), namedLink("20other", file("other"), 5))
+	a := shard(
+		namedLink("0Afoo", file("foo"), 3),
+		namedLink("0Bbar", file("bar"), 3),
+		namedLink("1C", shard(namedLink("05baz", file("baz"), 3), namedLink("FFqux", file("qux"), 3)), 100),
+		namedLink("2A", sharedShard, 100),
+	)
+	b := shard(
+		namedLink("0Afoo", file("foo"), 3),
+		namedLink("0Dnew", file("new"), 3),
+		namedLink("1C", shard(namedLink("05baz", file("baz2"), 4), namedLink("FFqux", file("qux"), 3)), 100),
+		namedLink("2A", sharedShard, 100),
+	)
+
+	loaded := recordLoads(lsys)
+	if changes := diffDAG(t, lsys, a, b); changes != "bar removed, new added, baz changed" {
+		t.Fatalf("unexpected changes: %s", changes)
+	}
+	if loaded[sharedShard.String()] {
+		t.Fatal("a shared shard was loaded")
+	}
+
+	// a bucket that's an entry on one side and a shard on the other is
+	// compared by the entries under it
+	c := shard(
+		namedLink("0Afoo", file("foo"), 3),
+		namedLink("0Bbar", file("bar"), 3),
+		namedLink("1Cqux", file("qux"), 3), // the shard collapsed to its remaining entry
+		namedLink("2A", sharedShard, 100),
+	)
+	if changes := diffDAG(t, lsys, a, c); changes != "baz removed" {
+		t.Fatalf("unexpected changes: %s", changes)
+	}
+
+	// a HAMT compared with a plain directory is compared by entry Names
+	plain := storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: []pbLink{
+		namedLink("bar", file("bar"), 3),
+		namedLink("baz", file("baz"), 3),
+		namedLink("foo", file("foo"), 3),
+		namedLink("other", file("other"), 5),
+		namedLink("qux", file("qux2"), 4),
+		namedLink("same", file("same"), 4),
+	}})
+	if changes := diffDAG(t, lsys, a, plain); changes != "qux changed" {
+		t.Fatalf("unexpected changes: %s", changes)
+	}
+}
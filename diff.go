@@ -0,0 +1,411 @@
+package dagpb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ErrPatchMismatch is returned by Apply when the PBNode being patched does
+// not match the base the Patch was made from.
+var ErrPatchMismatch = fmt.Errorf("dagpb: patch does not match the node")
+
+// LinkOp is the kind of change made to a link, as found by Diff.
+type LinkOp string
+
+const (
+	// LinkAdded is a link present only in the new node.
+	LinkAdded LinkOp = "add"
+	// LinkRemoved is a link present only in the old node.
+	LinkRemoved LinkOp = "remove"
+	// LinkChanged is a link with the same Name in both nodes, but a
+	// different Hash or Tsize (or a Name that went from absent to empty or
+	// back, which is a difference in the encoded form).
+	LinkChanged LinkOp = "change"
+	// LinkRenamed is a link removed from one Name and added under another,
+	// with the same Hash.
+	LinkRenamed LinkOp = "rename"
+)
+
+// LinkChange is a single difference between the links of two PBNodes.
+//
+// Links are matched by Name. Since a PBNode may hold more than one link
+// with the same Name (commonly the unnamed links of a file), a link is
+// located by its Name along with an index counting from 0 among the links
+// with that Name, in the order they appear.
+type LinkChange struct {
+	Op LinkOp
+	// Old is the link in the old node, and Index locates it. Old is nil for
+	// LinkAdded.
+	Old   PBLink
+	Index int
+	// New is the link in the new node, and NewIndex locates it. New is nil
+	// for LinkRemoved.
+	New      PBLink
+	NewIndex int
+}
+
+// Name returns the Name of the changed link, the old Name where a link was
+// renamed. An absent Name is returned as an empty one.
+func (c LinkChange) Name() string {
+	if c.Old != nil {
+		return c.Old.sortName()
+	}
+	return c.New.sortName()
+}
+
+// DataChange is a difference between the Data of two PBNodes. A nil slice
+// stands for absent Data.
+type DataChange struct {
+	Old []byte
+	New []byte
+}
+
+// Patch holds the differences between two PBNodes, as found by Diff, and can
+// be used by Apply to reproduce the second from the first. Links holds the
+// link changes ordered by Name, and Data is nil where the Data is unchanged.
+//
+// A Patch can be converted to and from an IPLD node with AsNode and
+// PatchFromNode, for storage or display in a codec such as DAG-JSON.
+type Patch struct {
+	Links []LinkChange
+	Data  *DataChange
+}
+
+// IsEmpty returns true where the Patch holds no changes.
+func (p *Patch) IsEmpty() bool {
+	return len(p.Links) == 0 && p.Data == nil
+}
+
+// Diff compares the links and Data of two PBNodes and returns the changes
+// needed to turn a into b.
+func Diff(a, b PBNode) *Patch {
+	patch := &Patch{}
+
+	aGroups, aNames := groupLinks(a.Links.x)
+	bGroups, bNames := groupLinks(b.Links.x)
+	names := aNames
+	for _, name := range bNames {
+		if _, ok := aGroups[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var removed, added []int // indexes into patch.Links
+	for _, name := range names {
+		ag, bg := aGroups[name], bGroups[name]
+		for k := 0; k < len(ag) || k < len(bg); k++ {
+			switch {
+			case k >= len(bg):
+				removed = append(removed, len(patch.Links))
+				patch.Links = append(patch.Links, LinkChange{Op: LinkRemoved, Old: ag[k], Index: k})
+			case k >= len(ag):
+				added = append(added, len(patch.Links))
+				patch.Links = append(patch.Links, LinkChange{Op: LinkAdded, New: bg[k], NewIndex: k})
+			case !pbLinkEqual(ag[k], bg[k]):
+				patch.Links = append(patch.Links, LinkChange{Op: LinkChanged, Old: ag[k], Index: k, New: bg[k], NewIndex: k})
+			}
+		}
+	}
+
+	// pair up removals and additions of the same Hash as renames
+	drop := make(map[int]bool)
+	for _, ri := range removed {
+		for _, ai := range added {
+			if drop[ai] {
+				continue
+			}
+			r, a := &patch.Links[ri], patch.Links[ai]
+			if pbLinkHashEqual(r.Old, a.New) {
+				r.Op = LinkRenamed
+				r.New = a.New
+				r.NewIndex = a.NewIndex
+				drop[ai] = true
+				break
+			}
+		}
+	}
+	if len(drop) > 0 {
+		kept := patch.Links[:0]
+		for ii, c := range patch.Links {
+			if !drop[ii] {
+				kept = append(kept, c)
+			}
+		}
+		patch.Links = kept
+	}
+
+	aData, bData := maybeData(a), maybeData(b)
+	if (aData == nil) != (bData == nil) || !bytes.Equal(aData, bData) {
+		patch.Data = &DataChange{Old: aData, New: bData}
+	}
+	return patch
+}
+
+// Apply reproduces the new node of a Diff from its old node. The node being
+// patched is checked against the old links and Data recorded in the Patch,
+// and ErrPatchMismatch is returned where they differ.
+func Apply(node PBNode, patch *Patch) (PBNode, error) {
+	groups, names := groupLinks(node.Links.x)
+
+	var removals, additions []LinkChange
+	for _, c := range patch.Links {
+		if c.Old != nil {
+			name := c.Old.sortName()
+			g := groups[name]
+			if c.Index < 0 || c.Index >= len(g) || !pbLinkEqual(g[c.Index], c.Old) {
+				return nil, fmt.Errorf("%w: %s of link %q at index %d", ErrPatchMismatch, c.Op, name, c.Index)
+			}
+		}
+		switch c.Op {
+		case LinkChanged:
+			if c.Old == nil || c.New == nil || c.Old.sortName() != c.New.sortName() || c.Index != c.NewIndex {
+				return nil, fmt.Errorf("dagpb: invalid patch, malformed %s", c.Op)
+			}
+			groups[c.Old.sortName()][c.Index] = c.New
+		case LinkRemoved:
+			if c.Old == nil {
+				return nil, fmt.Errorf("dagpb: invalid patch, malformed %s", c.Op)
+			}
+			removals = append(removals, c)
+		case LinkAdded:
+			if c.New == nil {
+				return nil, fmt.Errorf("dagpb: invalid patch, malformed %s", c.Op)
+			}
+			additions = append(additions, c)
+		case LinkRenamed:
+			if c.Old == nil || c.New == nil {
+				return nil, fmt.Errorf("dagpb: invalid patch, malformed %s", c.Op)
+			}
+			removals = append(removals, c)
+			additions = append(additions, c)
+		default:
+			return nil, fmt.Errorf("dagpb: invalid patch, unknown link operation %q", c.Op)
+		}
+		if c.New != nil {
+			if err := validatePBLink(c.New); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// removals come off the end of each group, and additions go on the end
+	sort.SliceStable(removals, func(i, j int) bool { return removals[i].Index > removals[j].Index })
+	for _, c := range removals {
+		name := c.Old.sortName()
+		g := groups[name]
+		if c.Index != len(g)-1 {
+			return nil, fmt.Errorf("%w: %s of link %q at index %d is not the last link with that Name", ErrPatchMismatch, c.Op, name, c.Index)
+		}
+		groups[name] = g[:len(g)-1]
+	}
+	sort.SliceStable(additions, func(i, j int) bool { return additions[i].NewIndex < additions[j].NewIndex })
+	for _, c := range additions {
+		name := c.New.sortName()
+		g, ok := groups[name]
+		if !ok {
+			names = append(names, name)
+		}
+		if c.NewIndex != len(g) {
+			return nil, fmt.Errorf("%w: %s of link %q at index %d, which has %d links with that Name", ErrPatchMismatch, c.Op, name, c.NewIndex, len(g))
+		}
+		groups[name] = append(g, c.New)
+	}
+
+	data, hasData := maybeData(node), node.Data.Exists()
+	if patch.Data != nil {
+		if (data == nil) != (patch.Data.Old == nil) || !bytes.Equal(data, patch.Data.Old) {
+			return nil, fmt.Errorf("%w: Data differs", ErrPatchMismatch)
+		}
+		data, hasData = patch.Data.New, patch.Data.New != nil
+	}
+
+	sort.Strings(names)
+	links := make([]_PBLink, 0, len(node.Links.x))
+	for _, name := range names {
+		for _, l := range groups[name] {
+			links = append(links, *l)
+		}
+	}
+	return newPBNode(links, data, hasData), nil
+}
+
+// groupLinks collects links by Name, in order, and lists the distinct Names
+// in the order they're first seen.
+func groupLinks(links []_PBLink) (map[string][]PBLink, []string) {
+	groups := make(map[string][]PBLink)
+	var names []string
+	for ii := range links {
+		name := links[ii].sortName()
+		g, ok := groups[name]
+		if !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(g, &links[ii])
+	}
+	return groups, names
+}
+
+// maybeData returns the Data of a PBNode, or nil where it is absent.
+func maybeData(node PBNode) []byte {
+	if !node.Data.Exists() {
+		return nil
+	}
+	if node.Data.v.x == nil {
+		return []byte{}
+	}
+	return node.Data.v.x
+}
+
+func pbLinkHashEqual(a, b PBLink) bool {
+	ac, aok := a.Hash.x.(cidlink.Link)
+	bc, bok := b.Hash.x.(cidlink.Link)
+	return aok && bok && ac.Cid.Equals(bc.Cid)
+}
+
+// pbLinkEqual compares links as they would be encoded.
+func pbLinkEqual(a, b PBLink) bool {
+	return pbLinkHashEqual(a, b) &&
+		a.Name.m == b.Name.m && a.Name.v.x == b.Name.v.x &&
+		a.Tsize.m == b.Tsize.m && a.Tsize.v.x == b.Tsize.v.x
+}
+
+// AsNode returns the Patch in IPLD Data Model form, suitable for encoding
+// with a codec such as DAG-JSON:
+//
+//	{
+//		"Links": [
+//			{"Op": "change", "Index": 0, "Old": {PBLink}, "NewIndex": 0, "New": {PBLink}},
+//			...
+//		],
+//		"Data": {"Old": Bytes, "New": Bytes}
+//	}
+//
+// Index and Old are left out for LinkAdded, NewIndex and New for
+// LinkRemoved. Data is left out where unchanged, and its Old or New is left
+// out where absent.
+func (p *Patch) AsNode() (ipld.Node, error) {
+	return fluent.BuildMap(basicnode.Prototype.Map, 2, func(fma fluent.MapAssembler) {
+		fma.AssembleEntry("Links").CreateList(int64(len(p.Links)), func(fla fluent.ListAssembler) {
+			for _, c := range p.Links {
+				fla.AssembleValue().CreateMap(5, func(fma fluent.MapAssembler) {
+					fma.AssembleEntry("Op").AssignString(string(c.Op))
+					if c.Old != nil {
+						fma.AssembleEntry("Index").AssignInt(int64(c.Index))
+						fma.AssembleEntry("Old").AssignNode(c.Old.Representation())
+					}
+					if c.New != nil {
+						fma.AssembleEntry("NewIndex").AssignInt(int64(c.NewIndex))
+						fma.AssembleEntry("New").AssignNode(c.New.Representation())
+					}
+				})
+			}
+		})
+		if p.Data != nil {
+			fma.AssembleEntry("Data").CreateMap(2, func(fma fluent.MapAssembler) {
+				if p.Data.Old != nil {
+					fma.AssembleEntry("Old").AssignBytes(p.Data.Old)
+				}
+				if p.Data.New != nil {
+					fma.AssembleEntry("New").AssignBytes(p.Data.New)
+				}
+			})
+		}
+	})
+}
+
+// PatchFromNode is the reverse of Patch.AsNode.
+func PatchFromNode(n ipld.Node) (*Patch, error) {
+	patch := &Patch{}
+
+	links, err := n.LookupByString("Links")
+	if err != nil {
+		return nil, err
+	}
+	itr := links.ListIterator()
+	if itr == nil {
+		return nil, fmt.Errorf("dagpb: invalid patch, Links must be a list")
+	}
+	for !itr.Done() {
+		_, cn, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		var c LinkChange
+		op, err := lookupString(cn, "Op")
+		if err != nil {
+			return nil, err
+		}
+		c.Op = LinkOp(op)
+		if c.Old, c.Index, err = lookupPatchLink(cn, "Old", "Index"); err != nil {
+			return nil, err
+		}
+		if c.New, c.NewIndex, err = lookupPatchLink(cn, "New", "NewIndex"); err != nil {
+			return nil, err
+		}
+		patch.Links = append(patch.Links, c)
+	}
+
+	data, err := n.LookupByString("Data")
+	if _, ok := err.(ipld.ErrNotExists); ok {
+		return patch, nil
+	} else if err != nil {
+		return nil, err
+	}
+	patch.Data = &DataChange{}
+	for _, f := range []struct {
+		key  string
+		dest *[]byte
+	}{{"Old", &patch.Data.Old}, {"New", &patch.Data.New}} {
+		v, err := data.LookupByString(f.key)
+		if _, ok := err.(ipld.ErrNotExists); ok {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if *f.dest, err = v.AsBytes(); err != nil {
+			return nil, err
+		}
+		if *f.dest == nil {
+			*f.dest = []byte{}
+		}
+	}
+	return patch, nil
+}
+
+func lookupString(n ipld.Node, key string) (string, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return "", err
+	}
+	return v.AsString()
+}
+
+// lookupPatchLink reads an optional PBLink and its index from a link change.
+func lookupPatchLink(n ipld.Node, linkKey string, indexKey string) (PBLink, int, error) {
+	ln, err := n.LookupByString(linkKey)
+	if _, ok := err.(ipld.ErrNotExists); ok {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	builder := Type.PBLink__Repr.NewBuilder()
+	if err := builder.AssignNode(ln); err != nil {
+		return nil, 0, err
+	}
+	in, err := n.LookupByString(indexKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	index, err := in.AsInt()
+	if err != nil {
+		return nil, 0, err
+	}
+	return builder.Build().(PBLink), int(index), nil
+}
@@ -0,0 +1,138 @@
+package dagpb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+func TestDiffApply(t *testing.T) {
+	c1 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	c2 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V")
+	c3 := mkcid(t, "QmUGhP2X8xo9dsj45vqx1H6i5WqPqLqmLQsHTTxd3ke8mp")
+	c4 := mkcid(t, "QmP7SrR76KHK9A916RbHG1ufy2TzNABZgiE23PjZDMzZXy")
+
+	a, err := NewNodeBuilder().SetData([]byte{8, 1}).
+		AddLink("", c1, 1).AddLink("", c2, 2).
+		AddLink("keep", c1, 10).AddLink("resize", c1, 20).AddLink("old", c3, 30).AddLink("gone", c2, 40).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNodeBuilder().SetData([]byte{8, 2}).
+		AddLink("", c1, 1).
+		AddLink("keep", c1, 10).AddLink("resize", c1, 21).AddLink("new", c3, 30).AddLink("added", c4, 50).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := Diff(a, b)
+	ops := make(map[string]LinkOp)
+	for _, c := range patch.Links {
+		ops[c.Name()] = c.Op
+	}
+	expected := map[string]LinkOp{"": LinkRemoved, "added": LinkAdded, "gone": LinkRemoved, "old": LinkRenamed, "resize": LinkChanged}
+	if len(ops) != len(expected) || len(patch.Links) != len(expected) {
+		t.Fatalf("unexpected changes: %v", ops)
+	}
+	for name, op := range expected {
+		if ops[name] != op {
+			t.Fatalf("expected %s of %q, got %v", op, name, ops)
+		}
+	}
+	if patch.Data == nil || !bytes.Equal(patch.Data.New, []byte{8, 2}) {
+		t.Fatal("expected a Data change")
+	}
+
+	applied, err := Apply(a, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mustEncode(t, applied), mustEncode(t, b)) {
+		t.Fatalf("applying the patch did not reproduce b, links %v", linkNames(applied))
+	}
+
+	if !Diff(a, a).IsEmpty() {
+		t.Fatal("expected no changes between a node and itself")
+	}
+
+	// the patch can't be applied to b, which is not its base
+	if _, err := Apply(b, patch); !errors.Is(err, ErrPatchMismatch) {
+		t.Fatalf("expected ErrPatchMismatch, got %v", err)
+	}
+	// nor to a with different Data
+	if _, err := Apply(WithData(a, []byte{8, 3}), patch); !errors.Is(err, ErrPatchMismatch) {
+		t.Fatalf("expected ErrPatchMismatch, got %v", err)
+	}
+}
+
+func TestDiffData(t *testing.T) {
+	withData := WithData(NewNodeBuilder().mustBuild(t), []byte{})
+	without := NewNodeBuilder().mustBuild(t)
+
+	// absent and empty Data encode differently, so they differ
+	patch := Diff(without, withData)
+	if patch.Data == nil || patch.Data.Old != nil || patch.Data.New == nil {
+		t.Fatalf("expected absent to empty Data change, got %+v", patch.Data)
+	}
+	applied, err := Apply(without, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mustEncode(t, applied), mustEncode(t, withData)) {
+		t.Fatal("applying the patch did not reproduce the empty Data")
+	}
+	if _, err := Apply(withData, patch); !errors.Is(err, ErrPatchMismatch) {
+		t.Fatalf("expected ErrPatchMismatch, got %v", err)
+	}
+}
+
+func TestPatchDagJSON(t *testing.T) {
+	c1 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	c2 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V")
+	a, err := NewNodeBuilder().AddLink("a", c1, 1).AddLink("b", c1, 2).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNodeBuilder().SetData([]byte("x")).AddLink("b", c2, 2).AddLink("c", c2, 3).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := Diff(a, b)
+
+	n, err := patch.AsNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Data":{"New":{"/":{"bytes":"eA"}}},"Links":[` +
+		`{"Index":0,"Old":{"Hash":{"/":"QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U"},"Name":"a","Tsize":1},"Op":"remove"},` +
+		`{"Index":0,"New":{"Hash":{"/":"QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V"},"Name":"b","Tsize":2},"NewIndex":0,"Old":{"Hash":{"/":"QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U"},"Name":"b","Tsize":2},"Op":"change"},` +
+		`{"New":{"Hash":{"/":"QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V"},"Name":"c","Tsize":3},"NewIndex":0,"Op":"add"}]}`
+	if buf.String() != expected {
+		t.Fatalf("unexpected dag-json:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, &buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := PatchFromNode(nb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := Apply(a, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mustEncode(t, applied), mustEncode(t, b)) {
+		t.Fatal("applying the decoded patch did not reproduce b")
+	}
+}
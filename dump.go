@@ -0,0 +1,307 @@
+package dagpb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// dumpBytesPerLine is the number of bytes shown on each line of a Dump
+const dumpBytesPerLine = 8
+
+// multicodec names for the codecs most likely to be seen in a link Hash
+var codecNames = map[uint64]string{
+	cid.Raw:         "raw",
+	cid.DagProtobuf: "dag-pb",
+	cid.DagCBOR:     "dag-cbor",
+	cid.DagJSON:     "dag-json",
+	cid.Libp2pKey:   "libp2p-key",
+	0x51:            "cbor",
+	0x0200:          "json",
+	0x78:            "git-raw",
+}
+
+// Dump writes an annotated listing of the DAG-PB bytes in src to w, showing
+// the offset of every tag, wire type, length prefix and payload, in the
+// spirit of `protoc --decode_raw`. The Hash of each link is also broken down
+// into its CID version, codec and multihash parts.
+//
+// Dump keeps going on malformed input. Problems are flagged in the listing
+// with "!!" and, where the bytes can't be parsed any further, the remainder
+// is listed as unparsed. The first problem found is returned once the
+// listing is complete, so the return value is nil only for a block that is
+// well-formed as far as Dump can tell; DecodeBytes remains the authority.
+// An error writing to w is returned in preference to any problem with src.
+func Dump(w io.Writer, src []byte) error {
+	d := &dumper{w: w}
+	d.node(src)
+	if d.werr != nil {
+		return d.werr
+	}
+	return d.perr
+}
+
+type dumper struct {
+	w    io.Writer
+	werr error // first error writing to w
+	perr error // first problem found in the input
+}
+
+// line writes the bytes of one element at the given offset along with its
+// description, wrapping long byte ranges onto following lines.
+func (d *dumper) line(off int, byts []byte, depth int, format string, args ...interface{}) {
+	if d.werr != nil {
+		return
+	}
+	desc := strings.Repeat("  ", depth) + fmt.Sprintf(format, args...)
+	for first := true; first || len(byts) > 0; first = false {
+		chunk := byts
+		if len(chunk) > dumpBytesPerLine {
+			chunk = chunk[:dumpBytesPerLine]
+		}
+		byts = byts[len(chunk):]
+		line := fmt.Sprintf("%06x  %-*s  %s", off, dumpBytesPerLine*3-1, fmt.Sprintf("% x", chunk), desc)
+		if _, err := fmt.Fprintln(d.w, strings.TrimRight(line, " ")); err != nil {
+			d.werr = err
+			return
+		}
+		off += len(chunk)
+		desc = ""
+	}
+}
+
+// problem flags a problem with the input at off, remembering the first one.
+func (d *dumper) problem(off int, depth int, err error) {
+	if d.perr == nil {
+		d.perr = fmt.Errorf("at offset %d: %w", off, err)
+	}
+	d.line(off, nil, depth, "!! %v", err)
+}
+
+// unparsed lists bytes that could not be parsed.
+func (d *dumper) unparsed(off int, byts []byte, depth int) {
+	if len(byts) > 0 {
+		d.line(off, byts, depth, "unparsed (%d bytes)", len(byts))
+	}
+}
+
+// field consumes one tag and its value from src (which starts at off). It
+// returns the field number, wire type, value payload (for wire type 2) and
+// the total number of bytes consumed, or a negative count where parsing
+// can't continue.
+func (d *dumper) field(src []byte, off int, depth int, names map[protowire.Number]string) (protowire.Number, protowire.Type, []byte, int) {
+	num, typ, n := protowire.ConsumeTag(src)
+	if n < 0 {
+		d.problem(off, depth, protowire.ParseError(n))
+		return 0, 0, nil, -1
+	}
+	name, ok := names[num]
+	if !ok {
+		name = "unknown field"
+	}
+	d.line(off, src[:n], depth, "%s tag (field %d, wire type %d)", name, num, typ)
+	tagLen := n
+
+	switch typ {
+	case protowire.BytesType:
+		length, n := protowire.ConsumeVarint(src[tagLen:])
+		if n < 0 {
+			d.problem(off+tagLen, depth+1, protowire.ParseError(n))
+			return 0, 0, nil, -1
+		}
+		d.line(off+tagLen, src[tagLen:tagLen+n], depth+1, "length %d", length)
+		start := tagLen + n
+		if length > uint64(len(src)-start) {
+			d.problem(off+start, depth+1, fmt.Errorf("length %d exceeds the %d bytes remaining: %w", length, len(src)-start, io.ErrUnexpectedEOF))
+			return 0, 0, nil, -1
+		}
+		return num, typ, src[start : start+int(length)], start + int(length)
+	case protowire.VarintType:
+		v, n := protowire.ConsumeVarint(src[tagLen:])
+		if n < 0 {
+			d.problem(off+tagLen, depth+1, protowire.ParseError(n))
+			return 0, 0, nil, -1
+		}
+		d.line(off+tagLen, src[tagLen:tagLen+n], depth+1, "varint %d", v)
+		return num, typ, nil, tagLen + n
+	default:
+		n := protowire.ConsumeFieldValue(num, typ, src[tagLen:])
+		if n < 0 {
+			d.problem(off+tagLen, depth+1, protowire.ParseError(n))
+			return 0, 0, nil, -1
+		}
+		d.line(off+tagLen, src[tagLen:tagLen+n], depth+1, "value (wire type %d)", typ)
+		return num, typ, nil, tagLen + n
+	}
+}
+
+var (
+	pbNodeFieldNames = map[protowire.Number]string{1: "Data", 2: "Links"}
+	pbLinkFieldNames = map[protowire.Number]string{1: "Hash", 2: "Name", 3: "Tsize"}
+)
+
+func (d *dumper) node(src []byte) {
+	off := 0
+	haveData := false
+	linkIndex := 0
+	linksEnded := false
+	for off < len(src) {
+		num, typ, payload, n := d.field(src[off:], off, 0, pbNodeFieldNames)
+		if n < 0 {
+			break
+		}
+		payloadOff := off + n - len(payload)
+		if typ != protowire.BytesType {
+			d.problem(off, 1, fmt.Errorf("protobuf: (PBNode) invalid wireType, expected 2, got %d", typ))
+			off += n
+			continue
+		}
+
+		switch num {
+		case 1:
+			if haveData {
+				d.problem(off, 1, fmt.Errorf("protobuf: (PBNode) duplicate Data section"))
+			}
+			if linkIndex > 0 {
+				linksEnded = true
+			}
+			haveData = true
+			d.line(payloadOff, payload, 1, "Data (%d bytes)", len(payload))
+		case 2:
+			if linksEnded {
+				d.problem(off, 1, fmt.Errorf("protobuf: (PBNode) duplicate Links section"))
+			}
+			d.line(payloadOff, nil, 1, "Links[%d]", linkIndex)
+			d.link(payload, payloadOff, 2)
+			linkIndex++
+		default:
+			d.problem(off, 1, fmt.Errorf("protobuf: (PBNode) invalid fieldNumber, expected 1 or 2, got %d", num))
+			d.unparsed(payloadOff, payload, 1)
+		}
+		off += n
+	}
+	d.unparsed(off, src[off:], 0)
+}
+
+func (d *dumper) link(src []byte, base int, depth int) {
+	off := 0
+	haveHash, haveName, haveTsize := false, false, false
+	for off < len(src) {
+		num, typ, payload, n := d.field(src[off:], base+off, depth, pbLinkFieldNames)
+		if n < 0 {
+			break
+		}
+		fieldOff := base + off
+		payloadOff := base + off + n - len(payload)
+		off += n
+
+		switch num {
+		case 1:
+			if haveHash {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) duplicate Hash section"))
+			} else if haveName || haveTsize {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) invalid order, found Hash after Name or Tsize"))
+			}
+			haveHash = true
+			if typ != protowire.BytesType {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Hash", typ))
+				continue
+			}
+			d.line(payloadOff, nil, depth+1, "Hash (%d bytes)", len(payload))
+			d.cid(payload, payloadOff, depth+2)
+		case 2:
+			if haveName {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) duplicate Name section"))
+			} else if haveTsize {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) invalid order, found Tsize before Name"))
+			}
+			haveName = true
+			if typ != protowire.BytesType {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Name", typ))
+				continue
+			}
+			d.line(payloadOff, payload, depth+1, "Name %q", payload)
+		case 3:
+			if haveTsize {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) duplicate Tsize section"))
+			}
+			haveTsize = true
+			if typ != protowire.VarintType {
+				d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Tsize", typ))
+			}
+		default:
+			d.problem(fieldOff, depth+1, fmt.Errorf("protobuf: (PBLink) invalid fieldNumber, expected 1, 2 or 3, got %d", num))
+		}
+	}
+	d.unparsed(base+off, src[off:], depth)
+	if !haveHash {
+		d.problem(base+off, depth, fmt.Errorf("invalid Hash field found in link, expected CID"))
+	}
+}
+
+// cid lists the parts of the CID bytes in src, which start at base.
+func (d *dumper) cid(src []byte, base int, depth int) {
+	off := 0
+	varint := func(what string, names map[uint64]string) (uint64, bool) {
+		v, n := protowire.ConsumeVarint(src[off:])
+		if n < 0 {
+			d.problem(base+off, depth, fmt.Errorf("invalid Hash field found in link, expected CID (%s: %v)", what, protowire.ParseError(n)))
+			return 0, false
+		}
+		if names == nil {
+			d.line(base+off, src[off:off+n], depth, "%s %d", what, v)
+		} else if name, ok := names[v]; ok {
+			d.line(base+off, src[off:off+n], depth, "%s %s (0x%x)", what, name, v)
+		} else {
+			d.line(base+off, src[off:off+n], depth, "%s 0x%x", what, v)
+		}
+		off += n
+		return v, true
+	}
+
+	if len(src) == 34 && src[0] == multihash.SHA2_256 && src[1] == 32 {
+		d.line(base, nil, depth, "CIDv0, codec dag-pb (0x70) implied")
+	} else {
+		version, ok := varint("CID version", nil)
+		if !ok {
+			d.unparsed(base+off, src[off:], depth)
+			return
+		}
+		if version != 1 {
+			d.problem(base, depth, fmt.Errorf("invalid Hash field found in link, expected CID (unsupported CID version %d)", version))
+			d.unparsed(base+off, src[off:], depth)
+			return
+		}
+		if _, ok := varint("codec", codecNames); !ok {
+			d.unparsed(base+off, src[off:], depth)
+			return
+		}
+	}
+
+	if _, ok := varint("multihash", multihash.Codes); !ok {
+		d.unparsed(base+off, src[off:], depth)
+		return
+	}
+	length, ok := varint("digest length", nil)
+	if !ok {
+		d.unparsed(base+off, src[off:], depth)
+		return
+	}
+	if length > uint64(len(src)-off) {
+		d.problem(base+off, depth, fmt.Errorf("invalid Hash field found in link, expected CID (digest length %d exceeds the %d bytes remaining)", length, len(src)-off))
+		d.unparsed(base+off, src[off:], depth)
+		return
+	}
+	digest := src[off : off+int(length)]
+	d.line(base+off, digest, depth, "digest %s", hex.EncodeToString(digest))
+	off += int(length)
+	if off < len(src) {
+		d.problem(base+off, depth, fmt.Errorf("invalid Hash field found in link, expected CID (%d trailing bytes)", len(src)-off))
+		d.unparsed(base+off, src[off:], depth)
+	}
+}
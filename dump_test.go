@@ -0,0 +1,116 @@
+package dagpb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	byts, _ := hex.DecodeString("12160a090155000500010203041209736f6d65206e616d650a050001020304")
+	var buf bytes.Buffer
+	if err := Dump(&buf, byts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `000000  12                       Links tag (field 2, wire type 2)
+000001  16                         length 22
+000002                             Links[0]
+000002  0a                           Hash tag (field 1, wire type 2)
+000003  09                             length 9
+000004                                 Hash (9 bytes)
+000004  01                               CID version 1
+000005  55                               codec raw (0x55)
+000006  00                               multihash identity (0x0)
+000007  05                               digest length 5
+000008  00 01 02 03 04                   digest 0001020304
+00000d  12                           Name tag (field 2, wire type 2)
+00000e  09                             length 9
+00000f  73 6f 6d 65 20 6e 61 6d        Name "some name"
+000017  65
+000018  0a                       Data tag (field 1, wire type 2)
+000019  05                         length 5
+00001a  00 01 02 03 04             Data (5 bytes)
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected dump:\n%s", buf.String())
+	}
+}
+
+func TestDumpCIDv0(t *testing.T) {
+	byts, _ := hex.DecodeString("12240a2212207521fe19c374a97759226dc5c0c8e674e73950e81b211f7dd3b6b30883a08a51")
+	var buf bytes.Buffer
+	if err := Dump(&buf, byts); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"CIDv0, codec dag-pb (0x70) implied",
+		"multihash sha2-256 (0x12)",
+		"digest length 32",
+		"digest 7521fe19c374a97759226dc5c0c8e674e73950e81b211f7dd3b6b30883a08a51",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("expected dump to contain %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestDumpMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		hex      string
+		errorStr string
+		contains []string
+	}{
+		{
+			name:     "truncated Data",
+			hex:      "0a0500010203",
+			errorStr: "at offset 2: length 5 exceeds the 4 bytes remaining: unexpected EOF",
+			contains: []string{"000000  0a 05 00 01 02 03        unparsed (6 bytes)"},
+		},
+		{
+			name:     "bad CID in second link",
+			hex:      "120b0a09015500050001020304120b0a09015500060001020304",
+			errorStr: "at offset 21: invalid Hash field found in link, expected CID (digest length 6 exceeds the 5 bytes remaining)",
+			contains: []string{"Links[1]", "000015  00 01 02 03 04                   unparsed (5 bytes)"},
+		},
+		{
+			name:     "Links without Hash",
+			hex:      "12021200",
+			errorStr: "at offset 4: invalid Hash field found in link, expected CID",
+			contains: []string{`Name ""`},
+		},
+		{
+			name:     "Links split by Data",
+			hex:      "120b0a090155000500010203040a00120b0a09015500050001020304",
+			errorStr: "at offset 15: protobuf: (PBNode) duplicate Links section",
+			contains: []string{"Links[1]"},
+		},
+		{
+			name:     "unknown field",
+			hex:      "1a0100",
+			errorStr: "at offset 0: protobuf: (PBNode) invalid fieldNumber, expected 1 or 2, got 3",
+			contains: []string{"unknown field tag (field 3, wire type 2)"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			byts, _ := hex.DecodeString(tc.hex)
+			var buf bytes.Buffer
+			err := Dump(&buf, byts)
+			if err == nil || err.Error() != tc.errorStr {
+				t.Fatalf("expected error %q, got %v", tc.errorStr, err)
+			}
+			if !strings.Contains(buf.String(), "!! ") {
+				t.Fatalf("expected the problem to be flagged in the dump:\n%s", buf.String())
+			}
+			for _, s := range tc.contains {
+				if !strings.Contains(buf.String(), s) {
+					t.Fatalf("expected dump to contain %q:\n%s", s, buf.String())
+				}
+			}
+			if DecodeBytes(Type.PBNode.NewBuilder(), byts) == nil {
+				t.Fatal("expected DecodeBytes to fail too")
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package dagpb
+
+import (
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multihash"
+	mhcore "github.com/multiformats/go-multihash/core"
+)
+
+// EncodeToCID encodes a node as AppendEncode does and returns the encoded
+// bytes along with their CID, made with prefix. The prefix must have the
+// DAG-PB codec (0x70) and may be CIDv0 (which requires SHA2-256) or CIDv1
+// with any hash function registered with go-multihash. An MhLength of -1
+// uses the default digest length of the hash function.
+//
+// The bytes are built in a single allocation of the exact size and hashed as
+// they are, which is cheaper than encoding to a growing buffer and hashing
+// the result with cid.Prefix.Sum.
+func EncodeToCID(node ipld.Node, prefix cid.Prefix) ([]byte, cid.Cid, error) {
+	hasher, err := newPrefixHasher(prefix)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	// 1KiB can be allocated on the stack, and covers the links of most small
+	// nodes.
+	head := make([]byte, 0, 1024)
+	head, data, hasData, err := appendEncodeLinks(head, node, nil)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	if hasData {
+		head = appendDataHeader(head, data)
+	}
+	enc := make([]byte, len(head)+len(data))
+	copy(enc, head)
+	copy(enc[len(head):], data)
+
+	hasher.Write(enc)
+	c, err := prefixHasherCID(prefix, hasher, len(enc))
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	return enc, c, nil
+}
+
+// EncodeWithCID is like Encode, but also returns the CID of the bytes
+// written, made with prefix as for EncodeToCID. The bytes are hashed as they
+// are written, and the Data is written to w and the hash directly, without
+// being copied into an encoding buffer first.
+func EncodeWithCID(node ipld.Node, w io.Writer, prefix cid.Prefix) (cid.Cid, error) {
+	hasher, err := newPrefixHasher(prefix)
+	if err != nil {
+		return cid.Undef, err
+	}
+	head := make([]byte, 0, 1024)
+	head, data, hasData, err := appendEncodeLinks(head, node, nil)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if hasData {
+		head = appendDataHeader(head, data)
+	}
+	mw := io.MultiWriter(w, hasher)
+	if _, err := mw.Write(head); err != nil {
+		return cid.Undef, err
+	}
+	if len(data) > 0 {
+		if _, err := mw.Write(data); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return prefixHasherCID(prefix, hasher, len(head)+len(data))
+}
+
+// newPrefixHasher checks that prefix describes a DAG-PB CID and returns a
+// hasher for it.
+func newPrefixHasher(prefix cid.Prefix) (hash.Hash, error) {
+	if prefix.Codec != cid.DagProtobuf {
+		return nil, fmt.Errorf("dagpb: CID prefix must have the DAG-PB codec (0x70), not 0x%x", prefix.Codec)
+	}
+	switch prefix.Version {
+	case 0:
+		if prefix.MhType != multihash.SHA2_256 || (prefix.MhLength != 32 && prefix.MhLength != -1) {
+			return nil, fmt.Errorf("dagpb: invalid CIDv0 prefix, CIDv0 must use a 32 byte SHA2-256")
+		}
+	case 1:
+	default:
+		return nil, fmt.Errorf("dagpb: invalid CID version %d", prefix.Version)
+	}
+	length := prefix.MhLength
+	if prefix.MhType == multihash.IDENTITY {
+		length = -1
+	}
+	return mhcore.GetVariableHasher(prefix.MhType, length)
+}
+
+// prefixHasherCID completes the CID for the size bytes written to hasher.
+func prefixHasherCID(prefix cid.Prefix, hasher hash.Hash, size int) (cid.Cid, error) {
+	digest := hasher.Sum(make([]byte, 0, 64))
+	length := prefix.MhLength
+	if length < 0 || prefix.MhType == multihash.IDENTITY {
+		length = len(digest)
+	}
+	if prefix.MhType == multihash.IDENTITY && prefix.MhLength >= 0 && prefix.MhLength != size {
+		return cid.Undef, fmt.Errorf("dagpb: the length of the identity hash (%d) must be equal to the length of the data (%d)", prefix.MhLength, size)
+	}
+	if len(digest) < length {
+		return cid.Undef, multihash.ErrLenTooLarge
+	}
+	mh, err := multihash.Encode(digest[:length], prefix.MhType)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if prefix.Version == 0 {
+		return cid.NewCidV0(mh), nil
+	}
+	return cid.NewCidV1(prefix.Codec, mh), nil
+}
@@ -0,0 +1,124 @@
+package dagpb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestEncodeToCID(t *testing.T) {
+	node, err := NewNodeBuilder().
+		SetData([]byte("some data")).
+		AddLink("some other link", mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V"), 8).
+		AddLink("some link", mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U"), 100000000).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mustEncode(t, node)
+
+	for _, prefix := range []cid.Prefix{
+		{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1},
+		{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: 32},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: 20},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_512, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA3_256, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.BLAKE2B_MIN + 31, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.BLAKE3, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.IDENTITY, MhLength: -1},
+	} {
+		t.Run(multihash.Codes[prefix.MhType], func(t *testing.T) {
+			expectedCid, err := prefix.Sum(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enc, c, err := EncodeToCID(node, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(enc, expected) || !c.Equals(expectedCid) {
+				t.Fatalf("EncodeToCID: got %s, expected %s", c, expectedCid)
+			}
+
+			var buf bytes.Buffer
+			c, err = EncodeWithCID(node, &buf, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) || !c.Equals(expectedCid) {
+				t.Fatalf("EncodeWithCID: got %s, expected %s", c, expectedCid)
+			}
+		})
+	}
+
+	for _, prefix := range []cid.Prefix{
+		{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1},
+		{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_512, MhLength: -1},
+		{Version: 2, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: 33},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: 0x9999, MhLength: -1},
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.IDENTITY, MhLength: 3},
+	} {
+		if _, _, err := EncodeToCID(node, prefix); err == nil {
+			t.Errorf("expected an error for prefix %v", prefix)
+		}
+	}
+}
+
+func benchmarkNode(b *testing.B) PBNode {
+	c, err := cid.Decode("QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	if err != nil {
+		b.Fatal(err)
+	}
+	builder := NewNodeBuilder().SetData(bytes.Repeat([]byte{0xab}, 256<<10))
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		builder.AddLink(name, c, 1024)
+	}
+	node, err := builder.Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return node
+}
+
+var benchmarkPrefix = cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}
+
+func BenchmarkEncodeThenSum(b *testing.B) {
+	node := benchmarkNode(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := Encode(node, &buf); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := benchmarkPrefix.Sum(buf.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeToCID(b *testing.B) {
+	node := benchmarkNode(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := EncodeToCID(node, benchmarkPrefix); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeWithCID(b *testing.B) {
+	node := benchmarkNode(b)
+	b.ReportAllocs()
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if _, err := EncodeWithCID(node, &buf, benchmarkPrefix); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,9 +5,13 @@ package dagpb_test
 import (
 	"bytes"
 	"encoding/hex"
+	"math"
 	"testing"
 
+	"github.com/ipfs/go-cid"
 	dagpb "github.com/ipld/go-codec-dagpb"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 )
 
@@ -42,3 +46,137 @@ func FuzzDecodeEncode(f *testing.F) {
 		// TODO: do we care that the re-encode matches byte by byte?
 	})
 }
+
+func FuzzSpliceLink(f *testing.F) {
+	for _, hexInput := range []string{
+		"120b0a09015500050001020304",                       // links with one hash
+		"12160a090155000500010203041209736f6d65206e616d65", // links with one hash and name
+		"12140a0901550005000102030418ffffffffffffff0f",     // links with one hash and tsize
+		// two named links with Tsizes, then Data
+		"12100a0901550005000102030412016118011210" +
+			"0a0901550005000102030412016218020a0464617461",
+		// as above, with the Data first and the links unsorted
+		"0a046461746112100a09015500050001020304120162180212100a0901550005000102030412016118" + "01",
+		// two links, the first with bytes after the CID in its Hash
+		"12100a09013030003030303030120130183012100a090130300530303030301201301830",
+		// canonical, but the second link has a CIDv2 Hash, which decoding
+		// rejects
+		"120e0a0901550005000102030412016112090a0402700000120162",
+	} {
+		p, err := hex.DecodeString(hexInput)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(p, uint(0), "", true, true, uint64(0), true)
+		f.Add(p, uint(1), "b", false, true, uint64(1<<40), true)
+		f.Add(p, uint(0), "", false, false, uint64(0), false) // neither Name nor Tsize
+		f.Add(p, uint(1), "a", true, true, uint64(7), false)  // no Tsize
+	}
+	f.Fuzz(func(t *testing.T, src []byte, index uint, name string, keepName bool, hasName bool, tsize uint64, hasTsize bool) {
+		// splice both the given bytes and their canonical form, where the
+		// links may be in a different order
+		for pass := 0; pass < 2; pass++ {
+			builder := dagpb.Type.PBNode.NewBuilder()
+			if err := dagpb.DecodeBytes(builder, src); err != nil {
+				// invalid dagpb bytes, which splicing must reject too
+				if x, err := dagpb.NewLinkIndex(src); err == nil && x.Len() > 0 {
+					i := int(index % uint(x.Len()))
+					link := fuzzPBLink(t, cidlink.Link{Cid: fuzzCid}, name, hasName, int64(tsize&math.MaxInt64), hasTsize)
+					if spliced, err := dagpb.SpliceLink(src, i, link); err == nil {
+						t.Fatalf("splice of link %d of invalid %x succeeded: %x", i, src, spliced)
+					}
+				}
+				return
+			}
+			node := builder.Build().(dagpb.PBNode)
+			links := node.FieldLinks()
+			if links.Length() == 0 {
+				return
+			}
+			i := int(index % uint(links.Length()))
+			old := links.Lookup(int64(i))
+			linkName, linkHasName := name, hasName
+			if keepName {
+				linkHasName = old.FieldName().Exists()
+				if linkHasName {
+					linkName = old.FieldName().Must().String()
+				}
+			}
+			link := fuzzPBLink(t, old.FieldHash().Link(), linkName, linkHasName, int64(tsize&math.MaxInt64), hasTsize)
+
+			// the full re-encode, with the link replaced
+			nb := dagpb.NewNodeBuilder()
+			for j := int64(0); j < links.Length(); j++ {
+				if j == int64(i) {
+					nb.AddPBLink(link)
+				} else {
+					nb.AddPBLink(links.Lookup(j))
+				}
+			}
+			if node.FieldData().Exists() {
+				nb.SetData(node.FieldData().Must().Bytes())
+			}
+			replaced, err := nb.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected bytes.Buffer
+			if err := dagpb.Encode(replaced, &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			spliced, err := dagpb.SpliceLink(src, i, link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(spliced, expected.Bytes()) {
+				t.Fatalf("splice of link %d of %x differs from a full encode:\n%x\n%x", i, src, spliced, expected.Bytes())
+			}
+
+			var canonical bytes.Buffer
+			if err := dagpb.Encode(node, &canonical); err != nil {
+				t.Fatal(err)
+			}
+			src = canonical.Bytes()
+		}
+	})
+}
+
+// fuzzCid is a valid Hash for replacement links.
+var fuzzCid = cid.NewCidV1(cid.Raw, []byte{0x00, 0x05, 0x00, 0x01, 0x02, 0x03, 0x04})
+
+// fuzzPBLink builds a PBLink with the Name and Tsize only where asked for,
+// which MakePBLink always sets.
+func fuzzPBLink(t *testing.T, hash ipld.Link, name string, hasName bool, tsize int64, hasTsize bool) dagpb.PBLink {
+	nb := dagpb.Type.PBLink.NewBuilder()
+	ma, err := nb.BeginMap(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.AssembleKey().AssignString("Hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.AssembleValue().AssignLink(hash); err != nil {
+		t.Fatal(err)
+	}
+	if hasName {
+		if err := ma.AssembleKey().AssignString("Name"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ma.AssembleValue().AssignString(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hasTsize {
+		if err := ma.AssembleKey().AssignString("Tsize"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ma.AssembleValue().AssignInt(tsize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ma.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return nb.Build().(dagpb.PBLink)
+}
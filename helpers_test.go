@@ -0,0 +1,78 @@
+package dagpb
+
+// Helpers shared by the tests of more than one file.
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+)
+
+var (
+	pbLinkProto  = cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: 0x70, MhType: 0x12, MhLength: 32}}
+	rawLinkProto = cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: 0x55, MhType: 0x12, MhLength: 32}}
+)
+
+// mkLinkSystem returns a LinkSystem backed by a fresh in-memory store
+func mkLinkSystem() (*ipld.LinkSystem, *memstore.Store) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	return &lsys, store
+}
+
+// mkPBNode builds a typed PBNode from the test form
+func mkPBNode(t *testing.T, n pbNode) PBNode {
+	if n.links == nil {
+		n.links = []pbLink{}
+	}
+	builder := Type.PBNode.NewBuilder()
+	if err := builder.AssignNode(buildNode(n)); err != nil {
+		t.Fatal(err)
+	}
+	return builder.Build().(PBNode)
+}
+
+func storeNode(t *testing.T, lsys *ipld.LinkSystem, n pbNode) cid.Cid {
+	lnk, err := lsys.Store(ipld.LinkContext{}, pbLinkProto, mkPBNode(t, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnk.(cidlink.Link).Cid
+}
+
+func storeRaw(t *testing.T, lsys *ipld.LinkSystem, byts []byte) cid.Cid {
+	lnk, err := lsys.Store(ipld.LinkContext{}, rawLinkProto, basicnode.NewBytes(byts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnk.(cidlink.Link).Cid
+}
+
+func namedLink(name string, c cid.Cid, tsize uint64) pbLink {
+	return pbLink{hash: c, name: name, hasName: true, tsize: tsize, hasTsize: true}
+}
+
+func mustEncode(t *testing.T, node PBNode) []byte {
+	t.Helper()
+	enc, err := AppendEncode(nil, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func (b *NodeBuilder) mustBuild(t *testing.T) PBNode {
+	t.Helper()
+	node, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
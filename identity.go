@@ -0,0 +1,93 @@
+package dagpb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+// IdentityCID returns a CIDv1 with the given codec that carries block inline,
+// in an identity multihash.
+func IdentityCID(codec uint64, block []byte) (cid.Cid, error) {
+	mh, err := multihash.Encode(block, multihash.IDENTITY)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(codec, mh), nil
+}
+
+// InlineLinks returns a copy of node where the Hash of each link to a block
+// of at most maxSize bytes is replaced by an identity CID carrying that
+// block, as made by IdentityCID with the codec of the original. Each linked
+// block is loaded from lsys to find its size; links that are already
+// identity CIDs are left alone, as are Name and Tsize. The original node is
+// returned where nothing was inlined.
+//
+// Loading every child to find its size can be costly, as for a directory of
+// large files. Links to raw blocks with a Tsize, which for a raw block is its
+// size, are skipped without loading where the Tsize is over maxSize. Other
+// children are loaded in full, as their Tsize does not give their own size.
+//
+// Links inlined this way can be read with a LinkSystem set up with
+// AddIdentitySupportToReadOpener.
+func InlineLinks(ctx context.Context, lsys *ipld.LinkSystem, node PBNode, maxSize int) (PBNode, error) {
+	var links []_PBLink // copied on the first change
+	for ii := range node.Links.x {
+		c := linkCid(&node.Links.x[ii])
+		if c.Prefix().MhType == multihash.IDENTITY {
+			continue
+		}
+		if l := &node.Links.x[ii]; c.Prefix().Codec == cid.Raw && l.Tsize.m == schema.Maybe_Value && l.Tsize.v.x > int64(maxSize) {
+			continue
+		}
+		block, err := lsys.LoadRaw(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c})
+		if err != nil {
+			return nil, err
+		}
+		if len(block) > maxSize {
+			continue
+		}
+		inline, err := IdentityCID(c.Prefix().Codec, block)
+		if err != nil {
+			return nil, err
+		}
+		if links == nil {
+			links = make([]_PBLink, len(node.Links.x))
+			copy(links, node.Links.x)
+		}
+		links[ii].Hash = _Link{cidlink.Link{Cid: inline}}
+	}
+	if links == nil {
+		return node, nil
+	}
+	return withLinks(node, links), nil
+}
+
+// AddIdentitySupportToReadOpener takes an existing BlockReadOpener and
+// serves identity CIDs from the bytes they carry, without calling it.
+// Other CIDs are passed through. A nil existing BlockReadOpener is allowed,
+// in which case only identity CIDs can be read.
+//
+//	lsys.StorageReadOpener = dagpb.AddIdentitySupportToReadOpener(lsys.StorageReadOpener)
+func AddIdentitySupportToReadOpener(existing ipld.BlockReadOpener) ipld.BlockReadOpener {
+	return func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		if cl, ok := l.(cidlink.Link); ok && cl.Cid.Prefix().MhType == multihash.IDENTITY {
+			dmh, err := multihash.Decode(cl.Cid.Hash())
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(dmh.Digest), nil
+		}
+		if existing == nil {
+			return nil, fmt.Errorf("dagpb: no storage to read %s from", l)
+		}
+		return existing(lc, l)
+	}
+}
@@ -0,0 +1,86 @@
+package dagpb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestInlineLinks(t *testing.T) {
+	ctx := context.Background()
+	lsys, _ := mkLinkSystem()
+	tiny := storeRaw(t, lsys, []byte("tiny"))
+	stub := storeNode(t, lsys, pbNode{data: []byte{8, 1}})
+	big := storeRaw(t, lsys, bytes.Repeat([]byte("big"), 100))
+	node, err := NewNodeBuilder().SetData([]byte{8, 1}).
+		AddLink("big", big, 300).AddLink("stub", stub, 4).AddLink("tiny", tiny, 4).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := recordLoads(lsys)
+	inlined, err := InlineLinks(ctx, lsys, node, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded[big.String()] || !loaded[tiny.String()] || !loaded[stub.String()] {
+		t.Fatalf("expected the big raw link to be skipped by its Tsize, loaded %v", loaded)
+	}
+	hashes := make([]cid.Cid, 3)
+	for ii := range hashes {
+		hashes[ii] = linkCid(inlined.FieldLinks().Lookup(int64(ii)))
+	}
+	if !hashes[0].Equals(big) {
+		t.Fatal("the big link should not be inlined")
+	}
+	for ii, codec := range []uint64{cid.DagProtobuf, cid.Raw} {
+		prefix := hashes[ii+1].Prefix()
+		if prefix.MhType != multihash.IDENTITY || prefix.Codec != codec || prefix.Version != 1 {
+			t.Fatalf("expected an identity CID with codec 0x%x, got %s", codec, hashes[ii+1])
+		}
+	}
+	if again, err := InlineLinks(ctx, lsys, inlined, 64); err != nil || again != inlined {
+		t.Fatalf("expected nothing more to inline, got %v", err)
+	}
+
+	// round-trip through the codec, then read the inlined children from a
+	// LinkSystem with no storage at all
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, mustEncode(t, inlined)); err != nil {
+		t.Fatal(err)
+	}
+	decoded := nb.Build().(PBNode)
+
+	empty := cidlink.DefaultLinkSystem()
+	empty.StorageReadOpener = AddIdentitySupportToReadOpener(nil)
+	stubNode, err := empty.Load(ipld.LinkContext{Ctx: ctx}, decoded.FieldLinks().Lookup(1).FieldHash().Link(), Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stubNode.(PBNode).FieldData().Must().Bytes(), []byte{8, 1}) {
+		t.Fatal("unexpected inlined dag-pb child")
+	}
+	tinyNode, err := empty.Load(ipld.LinkContext{Ctx: ctx}, decoded.FieldLinks().Lookup(2).FieldHash().Link(), basicnode.Prototype.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byts, _ := tinyNode.AsBytes(); string(byts) != "tiny" {
+		t.Fatalf("unexpected inlined raw child %q", byts)
+	}
+	if _, err := empty.LoadRaw(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: big}); err == nil {
+		t.Fatal("expected an error for a non-identity CID without storage")
+	}
+
+	// other CIDs still go to storage
+	lsys.StorageReadOpener = AddIdentitySupportToReadOpener(lsys.StorageReadOpener)
+	if _, err := lsys.LoadRaw(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: big}); err != nil {
+		t.Fatal(err)
+	}
+}
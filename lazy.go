@@ -0,0 +1,437 @@
+package dagpb
+
+import (
+	"sync"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/node/mixins"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+// LazyNode is a PBNode backed by its encoded form, read as it is used rather
+// than decoded up front. It is a schema.TypedNode of the PBNode type, with the
+// same fields, representation and prototype, so it can be used anywhere a
+// PBNode is taken as an ipld.Node. To make a PBNode proper, use the PBNode
+// method, or assign its Representation to a Type.PBNode builder. As with any
+// typed node other than a PBNode itself, the PBNode assembler does not take
+// the node directly where its Data is Absent.
+//
+// The block is indexed with a LinkIndex on first use, and each link is only
+// decoded, its Hash parsed, when it is looked up. A block that is not valid
+// DAG-PB is only found to be so when used, as errors from the lookups. A
+// LazyNode is safe for concurrent use.
+//
+// Encoding a LazyNode with Encode or AppendEncode copies the block as it is,
+// where it is canonical and no LinkPolicy is set. The links are checked first,
+// once, as DecodeBytes checks them, so that an invalid block is not copied,
+// but are not decoded into nodes. The block must not be changed while the
+// LazyNode is in use.
+type LazyNode struct {
+	src  []byte
+	once sync.Once
+	x    *LinkIndex
+	err  error
+}
+
+var _ schema.TypedNode = (*LazyNode)(nil)
+
+// NewLazyNode returns a LazyNode for the block in src, which is not read or
+// copied until the node is used.
+func NewLazyNode(src []byte) *LazyNode {
+	return &LazyNode{src: src}
+}
+
+// Bytes returns the block the LazyNode is backed by.
+func (n *LazyNode) Bytes() []byte {
+	return n.src
+}
+
+// Index returns the LinkIndex of the block, made on first use.
+func (n *LazyNode) Index() (*LinkIndex, error) {
+	n.once.Do(func() {
+		n.x, n.err = NewLinkIndex(n.src)
+	})
+	return n.x, n.err
+}
+
+// PBNode decodes the whole block into a PBNode.
+func (n *LazyNode) PBNode() (PBNode, error) {
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, n.src); err != nil {
+		return nil, err
+	}
+	return nb.Build().(PBNode), nil
+}
+
+func (*LazyNode) Kind() ipld.Kind {
+	return ipld.Kind_Map
+}
+
+func (n *LazyNode) LookupByString(key string) (ipld.Node, error) {
+	return n.lookup(key, false)
+}
+
+// lookup finds a field of the node, or of its representation where repr is
+// set.
+func (n *LazyNode) lookup(key string, repr bool) (ipld.Node, error) {
+	switch key {
+	case "Links", "Data":
+	default:
+		return nil, schema.ErrNoSuchField{Type: n.Type(), Field: ipld.PathSegmentOfString(key)}
+	}
+	x, err := n.Index()
+	if err != nil {
+		return nil, err
+	}
+	if key == "Links" {
+		return &lazyLinks{x: x, repr: repr}, nil
+	}
+	data, ok := x.Data()
+	if !ok {
+		if repr {
+			return ipld.Absent, ipld.ErrNotExists{Segment: ipld.PathSegmentOfString(key)}
+		}
+		return ipld.Absent, nil
+	}
+	if repr {
+		return (&_Bytes{x: data}).Representation(), nil
+	}
+	return &_Bytes{x: data}, nil
+}
+
+func (n *LazyNode) LookupByNode(key ipld.Node) (ipld.Node, error) {
+	ks, err := key.AsString()
+	if err != nil {
+		return nil, err
+	}
+	return n.LookupByString(ks)
+}
+
+func (*LazyNode) LookupByIndex(idx int64) (ipld.Node, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.LookupByIndex(idx)
+}
+
+func (n *LazyNode) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
+	return n.LookupByString(seg.String())
+}
+
+func (n *LazyNode) MapIterator() ipld.MapIterator {
+	return &lazyNodeMapItr{n: n}
+}
+
+// lazyNodeMapItr iterates over the fields of a LazyNode, where an absent Data
+// is Absent, or of its representation, where it is left out.
+type lazyNodeMapItr struct {
+	n    *LazyNode
+	repr bool
+	idx  int
+}
+
+func (itr *lazyNodeMapItr) Next() (k ipld.Node, v ipld.Node, err error) {
+	if itr.Done() {
+		return nil, nil, ipld.ErrIteratorOverread{}
+	}
+	switch itr.idx {
+	case 0:
+		k = &fieldName__PBNode_Links
+		v, err = itr.n.lookup("Links", itr.repr)
+	case 1:
+		k = &fieldName__PBNode_Data
+		v, err = itr.n.lookup("Data", itr.repr)
+	}
+	itr.idx++
+	return k, v, err
+}
+
+func (itr *lazyNodeMapItr) Done() bool {
+	if itr.repr {
+		return int64(itr.idx) >= itr.n.Representation().Length()
+	}
+	return itr.idx >= 2
+}
+
+func (*LazyNode) ListIterator() ipld.ListIterator {
+	return nil
+}
+
+func (*LazyNode) Length() int64 {
+	return 2
+}
+
+func (*LazyNode) IsAbsent() bool {
+	return false
+}
+
+func (*LazyNode) IsNull() bool {
+	return false
+}
+
+func (*LazyNode) AsBool() (bool, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsBool()
+}
+
+func (*LazyNode) AsInt() (int64, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsInt()
+}
+
+func (*LazyNode) AsFloat() (float64, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsFloat()
+}
+
+func (*LazyNode) AsString() (string, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsString()
+}
+
+func (*LazyNode) AsBytes() ([]byte, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsBytes()
+}
+
+func (*LazyNode) AsLink() (ipld.Link, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode"}.AsLink()
+}
+
+func (*LazyNode) Prototype() ipld.NodePrototype {
+	return _PBNode__Prototype{}
+}
+
+func (*LazyNode) Type() schema.Type {
+	return PBNode(nil).Type()
+}
+
+func (n *LazyNode) Representation() ipld.Node {
+	return &lazyNodeRepr{n}
+}
+
+// lazyNodeRepr is the representation of a LazyNode, which is that of PBNode.
+type lazyNodeRepr struct {
+	n *LazyNode
+}
+
+func (lazyNodeRepr) Kind() ipld.Kind {
+	return ipld.Kind_Map
+}
+
+func (r *lazyNodeRepr) LookupByString(key string) (ipld.Node, error) {
+	return r.n.lookup(key, true)
+}
+
+func (r *lazyNodeRepr) LookupByNode(key ipld.Node) (ipld.Node, error) {
+	ks, err := key.AsString()
+	if err != nil {
+		return nil, err
+	}
+	return r.LookupByString(ks)
+}
+
+func (lazyNodeRepr) LookupByIndex(idx int64) (ipld.Node, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.LookupByIndex(idx)
+}
+
+func (r *lazyNodeRepr) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
+	return r.LookupByString(seg.String())
+}
+
+func (r *lazyNodeRepr) MapIterator() ipld.MapIterator {
+	return &lazyNodeMapItr{n: r.n, repr: true}
+}
+
+func (lazyNodeRepr) ListIterator() ipld.ListIterator {
+	return nil
+}
+
+// Length is 1 where the Data is absent, or the block is not valid.
+func (r *lazyNodeRepr) Length() int64 {
+	if x, err := r.n.Index(); err == nil {
+		if _, ok := x.Data(); ok {
+			return 2
+		}
+	}
+	return 1
+}
+
+func (lazyNodeRepr) IsAbsent() bool {
+	return false
+}
+
+func (lazyNodeRepr) IsNull() bool {
+	return false
+}
+
+func (lazyNodeRepr) AsBool() (bool, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsBool()
+}
+
+func (lazyNodeRepr) AsInt() (int64, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsInt()
+}
+
+func (lazyNodeRepr) AsFloat() (float64, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsFloat()
+}
+
+func (lazyNodeRepr) AsString() (string, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsString()
+}
+
+func (lazyNodeRepr) AsBytes() ([]byte, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsBytes()
+}
+
+func (lazyNodeRepr) AsLink() (ipld.Link, error) {
+	return mixins.Map{TypeName: "dagpb.PBNode.Repr"}.AsLink()
+}
+
+func (lazyNodeRepr) Prototype() ipld.NodePrototype {
+	return _PBNode__ReprPrototype{}
+}
+
+// lazyLinks is the Links of a LazyNode, or their representation where repr
+// is set, decoding each link as it is looked up.
+type lazyLinks struct {
+	x    *LinkIndex
+	repr bool
+}
+
+var _ schema.TypedNode = (*lazyLinks)(nil)
+
+func (lazyLinks) Kind() ipld.Kind {
+	return ipld.Kind_List
+}
+
+func (l *lazyLinks) typeName() string {
+	if l.repr {
+		return "dagpb.PBLinks.Repr"
+	}
+	return "dagpb.PBLinks"
+}
+
+func (l *lazyLinks) LookupByString(string) (ipld.Node, error) {
+	return mixins.List{TypeName: l.typeName()}.LookupByString("")
+}
+
+func (l *lazyLinks) LookupByNode(k ipld.Node) (ipld.Node, error) {
+	idx, err := k.AsInt()
+	if err != nil {
+		return nil, err
+	}
+	return l.LookupByIndex(idx)
+}
+
+func (l *lazyLinks) LookupByIndex(idx int64) (ipld.Node, error) {
+	if idx < 0 || int64(l.x.Len()) <= idx {
+		return nil, ipld.ErrNotExists{Segment: ipld.PathSegmentOfInt(idx)}
+	}
+	link, err := l.x.Link(int(idx))
+	if err != nil {
+		return nil, err
+	}
+	if l.repr {
+		return link.Representation(), nil
+	}
+	return link, nil
+}
+
+func (l *lazyLinks) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
+	i, err := seg.Index()
+	if err != nil {
+		return nil, ipld.ErrInvalidSegmentForList{TypeName: l.typeName(), TroubleSegment: seg, Reason: err}
+	}
+	return l.LookupByIndex(i)
+}
+
+func (lazyLinks) MapIterator() ipld.MapIterator {
+	return nil
+}
+
+func (l *lazyLinks) ListIterator() ipld.ListIterator {
+	return &lazyLinksItr{l: l}
+}
+
+type lazyLinksItr struct {
+	l   *lazyLinks
+	idx int
+}
+
+func (itr *lazyLinksItr) Next() (int64, ipld.Node, error) {
+	if itr.Done() {
+		return -1, nil, ipld.ErrIteratorOverread{}
+	}
+	idx := int64(itr.idx)
+	itr.idx++
+	v, err := itr.l.LookupByIndex(idx)
+	return idx, v, err
+}
+
+func (itr *lazyLinksItr) Done() bool {
+	return itr.idx >= itr.l.x.Len()
+}
+
+func (l *lazyLinks) Length() int64 {
+	return int64(l.x.Len())
+}
+
+func (lazyLinks) IsAbsent() bool {
+	return false
+}
+
+func (lazyLinks) IsNull() bool {
+	return false
+}
+
+func (l *lazyLinks) AsBool() (bool, error) {
+	return mixins.List{TypeName: l.typeName()}.AsBool()
+}
+
+func (l *lazyLinks) AsInt() (int64, error) {
+	return mixins.List{TypeName: l.typeName()}.AsInt()
+}
+
+func (l *lazyLinks) AsFloat() (float64, error) {
+	return mixins.List{TypeName: l.typeName()}.AsFloat()
+}
+
+func (l *lazyLinks) AsString() (string, error) {
+	return mixins.List{TypeName: l.typeName()}.AsString()
+}
+
+func (l *lazyLinks) AsBytes() ([]byte, error) {
+	return mixins.List{TypeName: l.typeName()}.AsBytes()
+}
+
+func (l *lazyLinks) AsLink() (ipld.Link, error) {
+	return mixins.List{TypeName: l.typeName()}.AsLink()
+}
+
+func (l *lazyLinks) Prototype() ipld.NodePrototype {
+	if l.repr {
+		return _PBLinks__ReprPrototype{}
+	}
+	return _PBLinks__Prototype{}
+}
+
+func (lazyLinks) Type() schema.Type {
+	return PBLinks(nil).Type()
+}
+
+func (l *lazyLinks) Representation() ipld.Node {
+	return &lazyLinks{x: l.x, repr: true}
+}
+
+// asLazyNode returns node as a LazyNode where it is one, or its
+// representation.
+func asLazyNode(node ipld.Node) (*LazyNode, bool) {
+	switch v := node.(type) {
+	case *LazyNode:
+		return v, true
+	case *lazyNodeRepr:
+		return v.n, true
+	}
+	return nil, false
+}
+
+// canonical reports whether the block is canonical, with valid links, so that
+// it can be encoded as it is.
+func (n *LazyNode) canonical() bool {
+	x, err := n.Index()
+	return err == nil && x.Canonical() && x.check() == nil
+}
@@ -0,0 +1,166 @@
+package dagpb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+func TestLazyNode(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	for _, node := range []PBNode{
+		NewNodeBuilder().SetData([]byte("data")).AddLink("a", c, 1).AddLink("b", c, 2).mustBuild(t),
+		NewNodeBuilder().AddLink("", c, 1).mustBuild(t),
+		NewNodeBuilder().mustBuild(t),
+	} {
+		src := mustEncode(t, node)
+		lazy := NewLazyNode(src)
+		var _ schema.TypedNode = lazy
+
+		// the node and its representation look just like those of the
+		// PBNode, with an absent Data as Absent in the node only
+		if !datamodel.DeepEqual(node, lazy) || !datamodel.DeepEqual(node.Representation(), lazy.Representation()) {
+			t.Fatal("LazyNode differs from the PBNode")
+		}
+
+		// assigning the representation to the PBNode type, or its
+		// representation, makes the same node as decoding
+		for _, na := range []ipld.NodeBuilder{Type.PBNode.NewBuilder(), Type.PBNode__Repr.NewBuilder()} {
+			if err := na.AssignNode(lazy.Representation()); err != nil {
+				t.Fatal(err)
+			}
+			if built := na.Build().(PBNode); !bytes.Equal(mustEncode(t, built), src) || built.Links.Length() != node.Links.Length() {
+				t.Fatal("assigned node differs")
+			}
+		}
+
+		if decoded, err := lazy.PBNode(); err != nil || !bytes.Equal(mustEncode(t, decoded), src) {
+			t.Fatalf("decoded node differs, %v", err)
+		}
+
+		// encoding is a copy, without allocating anything
+		if enc, err := AppendEncode(nil, lazy); err != nil || !bytes.Equal(enc, src) {
+			t.Fatalf("encoded LazyNode differs, %v", err)
+		}
+		buf := make([]byte, 0, len(src))
+		if allocs := testing.AllocsPerRun(10, func() {
+			buf, _ = AppendEncode(buf[:0], lazy)
+		}); allocs != 0 {
+			t.Fatalf("expected no allocations encoding, got %v", allocs)
+		}
+
+		// and encoding it along with its CID
+		enc, c, err := EncodeToCID(lazy, pbLinkProto.Prefix)
+		if err != nil || !bytes.Equal(enc, src) {
+			t.Fatalf("EncodeToCID of LazyNode differs, %v", err)
+		}
+		var buf2 bytes.Buffer
+		if c2, err := EncodeWithCID(lazy, &buf2, pbLinkProto.Prefix); err != nil || !c2.Equals(c) || !bytes.Equal(buf2.Bytes(), src) {
+			t.Fatalf("EncodeWithCID of LazyNode differs, %v", err)
+		}
+
+		// as is storing it
+		lsys, _ := mkLinkSystem()
+		lnk, err := lsys.Store(ipld.LinkContext{}, pbLinkProto, lazy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := pbLinkProto.Sum(src)
+		if err != nil || !lnk.(cidlink.Link).Cid.Equals(expected) {
+			t.Fatalf("expected to store the block as it is, got %s", lnk)
+		}
+	}
+}
+
+func TestLazyNodeInvalidLinks(t *testing.T) {
+	for _, hexInput := range []string{
+		"1200",                       // a link without a Hash
+		"120b0a09025500050001020304", // a link with a CIDv2 Hash
+	} {
+		src, _ := hex.DecodeString(hexInput)
+		if err := DecodeBytes(Type.PBNode.NewBuilder(), src); err == nil {
+			t.Fatalf("expected %s to fail to decode", hexInput)
+		}
+		// laid out canonically, but not to be copied as it is
+		if x, err := NewLinkIndex(src); err != nil || !x.Canonical() {
+			t.Fatalf("expected %s to be indexed as canonical, %v", hexInput, err)
+		}
+		if enc, err := AppendEncode(nil, NewLazyNode(src)); err == nil {
+			t.Fatalf("expected an error encoding %s, got %x", hexInput, enc)
+		}
+	}
+}
+
+func TestLazyNodeLookups(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	// the first link's Hash is not a CID
+	src := appendLink(nil, pbLink{hash: c, name: "b", hasName: true})
+	src = append(append([]byte{0x12, 0x05, 0x0a, 0x01, 0xff, 0x12, 0x00}, src...), 0x0a, 0x01, 'd')
+	lazy := NewLazyNode(src)
+
+	data, err := lazy.LookupByString("Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byts, _ := data.AsBytes(); string(byts) != "d" {
+		t.Fatalf("unexpected Data %q", byts)
+	}
+	links, err := lazy.LookupByString("Links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links.Length() != 2 {
+		t.Fatalf("expected 2 links, got %d", links.Length())
+	}
+	link, err := links.LookupBySegment(ipld.PathSegmentOfInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := link.(PBLink).FieldName().AsNode().AsString(); name != "b" {
+		t.Fatalf("unexpected Name %q", name)
+	}
+	if _, err := links.LookupByIndex(0); err == nil {
+		t.Fatal("expected an error for a link with a bad Hash")
+	}
+	if _, err := links.LookupByIndex(2); !errors.As(err, new(ipld.ErrNotExists)) {
+		t.Fatalf("expected ErrNotExists, got %v", err)
+	}
+	if _, err := lazy.LookupByString("Nope"); err == nil {
+		t.Fatal("expected an error for a field not in PBNode")
+	}
+
+	// without Data, which is absent from the representation
+	lazy = NewLazyNode(appendLink(nil, pbLink{hash: c}))
+	if data, err := lazy.LookupByString("Data"); err != nil || !data.IsAbsent() {
+		t.Fatalf("expected an absent Data, got %v", err)
+	}
+	if repr := lazy.Representation(); repr.Length() != 1 {
+		t.Fatalf("expected a representation of 1 entry, got %d", repr.Length())
+	}
+
+	// a block that is not canonical is encoded in full
+	unsorted := append(appendLink(nil, pbLink{hash: c, name: "b", hasName: true}), appendLink(nil, pbLink{hash: c, name: "a", hasName: true})...)
+	enc, err := AppendEncode(nil, NewLazyNode(unsorted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nb := Type.PBNode.NewBuilder(); DecodeBytes(nb, unsorted) != nil || !bytes.Equal(enc, mustEncode(t, nb.Build().(PBNode))) {
+		t.Fatal("expected the block sorted as by decoding and encoding")
+	}
+
+	// an invalid block is only found to be so when used
+	lazy = NewLazyNode([]byte{0xff})
+	if _, err := lazy.LookupByString("Links"); err == nil {
+		t.Fatal("expected an error for an invalid block")
+	}
+	if err := Encode(lazy, new(bytes.Buffer)); err == nil {
+		t.Fatal("expected an error encoding an invalid block")
+	}
+}
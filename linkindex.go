@@ -0,0 +1,249 @@
+package dagpb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// LinkIndex gives random access to the links of an encoded DAG-PB block,
+// without decoding the block. It holds the block as given, which must not
+// be changed while the LinkIndex is in use, and the position of each link
+// within it. Links are only decoded as they are asked for.
+type LinkIndex struct {
+	src       []byte
+	spans     []linkSpan
+	data      []byte
+	hasData   bool
+	sorted    bool
+	canonical bool
+
+	checkOnce sync.Once
+	checkErr  error
+}
+
+// linkSpan is the position of a link within the block.
+type linkSpan struct {
+	off, len uint32
+}
+
+// NewLinkIndex indexes the links of the block in src, in one pass over the
+// bytes, which are not copied. The layout of the block is checked as by
+// DecodeBytes, and each link far enough to find its Name, but the Hash of
+// each is not decoded until asked for, by Link.
+func NewLinkIndex(src []byte) (*LinkIndex, error) {
+	if len(src) > math.MaxUint32 {
+		return nil, fmt.Errorf("dagpb: block too large to index (%d bytes)", len(src))
+	}
+	x := &LinkIndex{src: src, sorted: true, canonical: true}
+	var last []byte
+	linksBeforeData := 0
+	err := scanNode(src, func(data []byte) error {
+		x.data = data
+		x.hasData = true
+		linksBeforeData = len(x.spans)
+		if _, ok := shortestHeader(src, data, 1); !ok {
+			x.canonical = false
+		}
+		return nil
+	}, func(index int, chunk []byte) error {
+		name, _, shortest, err := scanLink(chunk)
+		if err != nil {
+			return err
+		}
+		if string(name) < string(last) {
+			x.sorted = false
+		}
+		last = name
+		if _, ok := shortestHeader(src, chunk, 2); !ok || !shortest {
+			x.canonical = false
+		}
+		x.spans = append(x.spans, linkSpan{off: uint32(cap(src) - cap(chunk)), len: uint32(len(chunk))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.canonical = x.canonical && x.sorted && (!x.hasData || linksBeforeData == len(x.spans))
+	return x, nil
+}
+
+// Len returns the number of links.
+func (x *LinkIndex) Len() int {
+	return len(x.spans)
+}
+
+// Raw returns the encoded form of link i, a slice of the block.
+func (x *LinkIndex) Raw(i int) []byte {
+	s := x.spans[i]
+	return x.src[s.off : s.off+s.len]
+}
+
+// Link decodes link i.
+func (x *LinkIndex) Link(i int) (PBLink, error) {
+	link, err := decodeLink(x.Raw(i))
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Name returns the Name of link i, and whether it has one, without decoding
+// the rest of the link.
+func (x *LinkIndex) Name(i int) (string, bool) {
+	name, hasName, _, _ := scanLink(x.Raw(i)) // checked by NewLinkIndex
+	return string(name), hasName
+}
+
+// Data returns the Data of the block, a slice of it, and whether it has one.
+func (x *LinkIndex) Data() ([]byte, bool) {
+	return x.data, x.hasData
+}
+
+// Sorted reports whether the links are sorted by Name, as Encode sorts them.
+// A link without a Name sorts as an empty Name.
+func (x *LinkIndex) Sorted() bool {
+	return x.sorted
+}
+
+// Canonical reports whether the fields of the block are laid out as Encode
+// lays them out: the links Sorted, all of them before the Data, and every
+// tag and length in its shortest form. Where the links are valid, as Link
+// checks, the block is then byte for byte what Encode would produce.
+func (x *LinkIndex) Canonical() bool {
+	return x.canonical
+}
+
+// check checks every link as DecodeBytes does, once, returning the first
+// error found.
+func (x *LinkIndex) check() error {
+	x.checkOnce.Do(func() {
+		for ii := range x.spans {
+			if err := visitLink(x.Raw(ii), discardVisitor{}, nil, ii); err != nil {
+				x.checkErr = err
+				return
+			}
+		}
+	})
+	return x.checkErr
+}
+
+// Find returns the index of the first link with the given Name, and whether
+// there is one. Where the links are Sorted this is a binary search, and the
+// index is otherwise where such a link would go; else every link is checked,
+// and the index is Len where there is none.
+func (x *LinkIndex) Find(name string) (int, bool) {
+	if !x.sorted {
+		for ii := range x.spans {
+			if n, _ := x.Name(ii); n == name {
+				return ii, true
+			}
+		}
+		return len(x.spans), false
+	}
+	ii := sort.Search(len(x.spans), func(i int) bool {
+		n, _ := x.Name(i)
+		return n >= name
+	})
+	if ii < len(x.spans) {
+		if n, _ := x.Name(ii); n == name {
+			return ii, true
+		}
+	}
+	return ii, false
+}
+
+// scanLink finds the Name within an encoded link, checking the layout of the
+// link as far as it goes, but not decoding the Hash. It also reports whether
+// the fields are in order and in their shortest form, as Encode writes them,
+// with nothing after the CID in the Hash.
+func scanLink(chunk []byte) (name []byte, hasName bool, shortest bool, err error) {
+	shortest = true
+	remaining := chunk
+	lastField := protowire.Number(0)
+	for len(remaining) > 0 {
+		fieldNum, wireType, n := protowire.ConsumeTag(remaining)
+		if n < 0 {
+			return nil, false, false, protowire.ParseError(n)
+		}
+		if fieldNum <= lastField || n != protowire.SizeTag(fieldNum) {
+			shortest = false
+		}
+		lastField = fieldNum
+		remaining = remaining[n:]
+		switch {
+		case (fieldNum == 1 || fieldNum == 2) && wireType == protowire.BytesType:
+			field, n := protowire.ConsumeBytes(remaining)
+			if n < 0 {
+				return nil, false, false, protowire.ParseError(n)
+			}
+			if n != protowire.SizeBytes(len(field)) {
+				shortest = false
+			}
+			if fieldNum == 1 && cidLength(field) != len(field) {
+				// decoding ignores anything after the CID
+				shortest = false
+			}
+			if fieldNum == 2 && !hasName {
+				name, hasName = field, true
+			}
+			remaining = remaining[n:]
+		case fieldNum == 3 && wireType == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return nil, false, false, protowire.ParseError(n)
+			}
+			if n != protowire.SizeVarint(v) {
+				shortest = false
+			}
+			remaining = remaining[n:]
+		default:
+			// invalid, for Link to report
+			return name, hasName, false, nil
+		}
+	}
+	return name, hasName, shortest, nil
+}
+
+// cidLength returns the length of the CID at the start of b, found without
+// decoding it, or -1 where it cannot be found.
+func cidLength(b []byte) int {
+	if len(b) >= 2 && b[0] == 0x12 && b[1] == 0x20 {
+		return 34 // CIDv0, a bare SHA2-256 multihash
+	}
+	// version, codec, multihash code and digest length
+	off := 0
+	var v uint64
+	for range 4 {
+		var n int
+		v, n = protowire.ConsumeVarint(b[off:])
+		if n < 0 {
+			return -1
+		}
+		off += n
+	}
+	if v > uint64(len(b)) {
+		return -1
+	}
+	return off + int(v)
+}
+
+// shortestHeader returns the offset in src of the tag and length that precede
+// chunk, a field of the given number sliced from src, and whether they are in
+// their shortest form.
+func shortestHeader(src []byte, chunk []byte, fieldNum protowire.Number) (int, bool) {
+	off := cap(src) - cap(chunk)
+	start := off - protowire.SizeTag(fieldNum) - protowire.SizeVarint(uint64(len(chunk)))
+	if start < 0 {
+		return 0, false
+	}
+	num, typ, n := protowire.ConsumeTag(src[start:])
+	if n != protowire.SizeTag(fieldNum) || num != fieldNum || typ != protowire.BytesType {
+		return 0, false
+	}
+	l, m := protowire.ConsumeVarint(src[start+n:])
+	return start, l == uint64(len(chunk)) && start+n+m == off
+}
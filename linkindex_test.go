@@ -0,0 +1,142 @@
+package dagpb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestLinkIndex(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	nb := NewNodeBuilder().SetData([]byte("data"))
+	for ii := 0; ii < 200; ii++ {
+		nb.AddLink(fmt.Sprintf("%04d", ii), c, uint64(ii))
+	}
+	node := nb.mustBuild(t)
+	src := mustEncode(t, node)
+
+	x, err := NewLinkIndex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 200 || !x.Sorted() || !x.Canonical() {
+		t.Fatalf("unexpected index of %d links, sorted %v, canonical %v", x.Len(), x.Sorted(), x.Canonical())
+	}
+	for _, ii := range []int{0, 150, 199} {
+		link, err := x.Link(ii)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pbLinkEqual(link, node.Links.Lookup(int64(ii))) {
+			t.Fatalf("link %d differs from the decoded node", ii)
+		}
+	}
+	if raw := x.Raw(150); &raw[0] != &src[cap(src)-cap(raw)] || cap(src)-cap(raw) >= len(src) {
+		t.Fatal("expected Raw to be a slice of the block")
+	}
+	if data, ok := x.Data(); !ok || string(data) != "data" {
+		t.Fatalf("unexpected Data %q", data)
+	}
+	if ii, ok := x.Find("0150"); !ok || ii != 150 {
+		t.Fatalf("expected to find link 150, got %d %v", ii, ok)
+	}
+	if ii, ok := x.Find("0150a"); ok || ii != 151 {
+		t.Fatalf("expected to not find a link, with index 151, got %d %v", ii, ok)
+	}
+
+	// Data before Links, as older blocks may have, and out of order
+	var links []byte
+	for _, name := range []string{"b", "a"} {
+		links = appendLink(links, pbLink{hash: c, name: name, hasName: true})
+	}
+	src = append(appendDataHeader(nil, []byte("data")), "data"...)
+	for _, b := range [][]byte{src, links} {
+		x, err = NewLinkIndex(append(b, links...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x.Sorted() || x.Canonical() {
+			t.Fatal("expected an unsorted index")
+		}
+		if ii, ok := x.Find("a"); !ok || ii != 1 {
+			t.Fatalf("expected to find link 1, got %d %v", ii, ok)
+		}
+		if name, ok := x.Name(0); !ok || name != "b" {
+			t.Fatalf("unexpected Name %q", name)
+		}
+	}
+	x, err = NewLinkIndex(append(appendLink(nil, pbLink{hash: c}), src...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.Sorted() || !x.Canonical() {
+		t.Fatal("expected a canonical index")
+	}
+	x, err = NewLinkIndex(append(src, appendLink(nil, pbLink{hash: c})...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.Sorted() || x.Canonical() {
+		t.Fatal("expected a sorted index, but not canonical with the Data first")
+	}
+
+	if _, err := NewLinkIndex(append(src, 0x0a, 0x00)); err == nil {
+		t.Fatal("expected an error for a duplicate Data")
+	}
+}
+
+func TestLinkIndexCanonical(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	// fields encodes a link named "a" with the given Hash and Tsize bytes
+	fields := func(hash []byte, tsize ...byte) []byte {
+		f := protowire.AppendTag(nil, 1, protowire.BytesType)
+		f = protowire.AppendBytes(f, hash)
+		f = protowire.AppendTag(f, 2, protowire.BytesType)
+		f = protowire.AppendString(f, "a")
+		f = protowire.AppendTag(f, 3, protowire.VarintType)
+		return append(f, tsize...)
+	}
+	// entry encodes fields as one of the Links, optionally with a length
+	// longer than it needs to be
+	entry := func(f []byte, long bool) []byte {
+		src := protowire.AppendTag(nil, 2, protowire.BytesType)
+		if long {
+			src = append(src, byte(len(f))|0x80, 0)
+		} else {
+			src = protowire.AppendVarint(src, uint64(len(f)))
+		}
+		return append(src, f...)
+	}
+	canonical := entry(fields(c.Bytes(), 1), false)
+	if !bytes.Equal(canonical, appendLink(nil, pbLink{hash: c, name: "a", hasName: true, tsize: 1, hasTsize: true})) {
+		t.Fatal("bad test encoding")
+	}
+
+	for _, tc := range []struct {
+		name      string
+		src       []byte
+		canonical bool
+	}{
+		{"canonical", canonical, true},
+		{"long length", entry(fields(c.Bytes(), 1), true), false},
+		{"long Tsize", entry(fields(c.Bytes(), 0x81, 0), false), false},
+		// decoding ignores anything after the CID
+		{"long Hash", entry(fields(append(c.Bytes(), 0), 1), false), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			x, err := NewLinkIndex(tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := x.Link(0); err != nil {
+				t.Fatal(err)
+			}
+			if x.Canonical() != tc.canonical {
+				t.Fatalf("expected Canonical %v", tc.canonical)
+			}
+		})
+	}
+}
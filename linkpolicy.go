@@ -0,0 +1,95 @@
+package dagpb
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// LinkPolicy restricts the CIDs allowed in link Hashes. Each restriction
+// left at its zero value allows anything.
+//
+// For example, to allow only CIDv1 links to DAG-PB and raw blocks hashed with
+// SHA2-256, or inlined with identity hashes of up to 64 bytes:
+//
+//	&dagpb.LinkPolicy{
+//		Versions:          []uint64{1},
+//		Codecs:            []uint64{cid.DagProtobuf, cid.Raw},
+//		MultihashCodes:    []uint64{multihash.SHA2_256, multihash.IDENTITY},
+//		MaxIdentityLength: 64,
+//	}
+type LinkPolicy struct {
+	// Versions lists the allowed CID versions.
+	Versions []uint64
+	// Codecs lists the allowed CID codecs.
+	Codecs []uint64
+	// MultihashCodes lists the allowed hash functions.
+	MultihashCodes []uint64
+	// MaxIdentityLength is the longest identity hash digest allowed. Leave
+	// identity out of MultihashCodes to disallow identity hashes entirely.
+	MaxIdentityLength int
+	// Check, if set, is called for any CID allowed by the other
+	// restrictions, and may reject it by returning an error.
+	Check func(cid.Cid) error
+}
+
+// LinkPolicyError is returned when a link Hash is not allowed by a
+// LinkPolicy. Index is the position of the link in the Links, in the order
+// they are encoded for decoding, and in the order of the node being encoded
+// (before sorting) for encoding.
+type LinkPolicyError struct {
+	Index int
+	Cid   cid.Cid
+	Err   error
+}
+
+func (e *LinkPolicyError) Error() string {
+	return fmt.Sprintf("dagpb: link %d (%s) not allowed: %v", e.Index, e.Cid, e.Err)
+}
+
+func (e *LinkPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// CheckLink returns an error describing why c is not allowed by the policy,
+// or nil if it is.
+func (p *LinkPolicy) CheckLink(c cid.Cid) error {
+	prefix := c.Prefix()
+	if len(p.Versions) > 0 && !containsCode(p.Versions, prefix.Version) {
+		return fmt.Errorf("CID version %d is not allowed", prefix.Version)
+	}
+	if len(p.Codecs) > 0 && !containsCode(p.Codecs, prefix.Codec) {
+		return fmt.Errorf("codec 0x%x is not allowed", prefix.Codec)
+	}
+	if len(p.MultihashCodes) > 0 && !containsCode(p.MultihashCodes, prefix.MhType) {
+		return fmt.Errorf("multihash 0x%x is not allowed", prefix.MhType)
+	}
+	if prefix.MhType == multihash.IDENTITY && p.MaxIdentityLength > 0 && prefix.MhLength > p.MaxIdentityLength {
+		return fmt.Errorf("identity hash of %d bytes is longer than %d", prefix.MhLength, p.MaxIdentityLength)
+	}
+	if p.Check != nil {
+		return p.Check(c)
+	}
+	return nil
+}
+
+// check is CheckLink for the link at index, allowing for a nil policy.
+func (p *LinkPolicy) check(c cid.Cid, index int) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.CheckLink(c); err != nil {
+		return &LinkPolicyError{Index: index, Cid: c, Err: err}
+	}
+	return nil
+}
+
+func containsCode(codes []uint64, code uint64) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
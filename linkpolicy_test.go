@@ -0,0 +1,111 @@
+package dagpb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestLinkPolicy(t *testing.T) {
+	sum := func(prefix cid.Prefix, data string) cid.Cid {
+		c, err := prefix.Sum([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	v0 := sum(cid.Prefix{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}, "a")
+	v1 := sum(cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}, "a")
+	raw := sum(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}, "a")
+	cbor := sum(cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}, "a")
+	sha512 := sum(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_512, MhLength: -1}, "a")
+	smallID := sum(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.IDENTITY, MhLength: -1}, "small")
+	bigID := sum(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.IDENTITY, MhLength: -1}, strings.Repeat("x", 100))
+
+	policy := &LinkPolicy{
+		Versions:          []uint64{1},
+		Codecs:            []uint64{cid.DagProtobuf, cid.Raw},
+		MultihashCodes:    []uint64{multihash.SHA2_256, multihash.IDENTITY},
+		MaxIdentityLength: 64,
+	}
+	for _, tc := range []struct {
+		name    string
+		c       cid.Cid
+		allowed bool
+	}{
+		{"CIDv1 dag-pb", v1, true},
+		{"CIDv1 raw", raw, true},
+		{"small identity", smallID, true},
+		{"CIDv0", v0, false},
+		{"dag-cbor", cbor, false},
+		{"sha2-512", sha512, false},
+		{"big identity", bigID, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := NewNodeBuilder().AddLink("a", v1, 1).AddLink("b", tc.c, 2).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc := mustEncode(t, node)
+
+			_, err = EncodeOptions{LinkPolicy: policy}.AppendEncode(nil, node)
+			checkPolicyError(t, err, tc.allowed, tc.c)
+
+			var buf bytes.Buffer
+			err = EncodeOptions{LinkPolicy: policy}.Encode(node, &buf)
+			checkPolicyError(t, err, tc.allowed, tc.c)
+
+			err = DecodeOptions{LinkPolicy: policy}.DecodeBytes(Type.PBNode.NewBuilder(), enc)
+			checkPolicyError(t, err, tc.allowed, tc.c)
+
+			err = DecodeOptions{LinkPolicy: policy}.Decode(Type.PBNode.NewBuilder(), bytes.NewReader(enc))
+			checkPolicyError(t, err, tc.allowed, tc.c)
+
+			// no policy, no restrictions
+			if err := DecodeBytes(Type.PBNode.NewBuilder(), enc); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// a custom check
+	denied := errors.New("denied")
+	custom := &LinkPolicy{Check: func(c cid.Cid) error {
+		if c.Equals(raw) {
+			return denied
+		}
+		return nil
+	}}
+	if err := custom.CheckLink(v0); err != nil {
+		t.Fatal(err)
+	}
+	node, err := NewNodeBuilder().AddLink("a", raw, 1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (EncodeOptions{LinkPolicy: custom}).AppendEncode(nil, node); !errors.Is(err, denied) {
+		t.Fatalf("expected the custom error, got %v", err)
+	}
+}
+
+// checkPolicyError checks for an error on the second link, at index 1
+func checkPolicyError(t *testing.T, err error, allowed bool, c cid.Cid) {
+	t.Helper()
+	if allowed {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	var policyErr *LinkPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a LinkPolicyError, got %v", err)
+	}
+	if policyErr.Index != 1 || !policyErr.Cid.Equals(c) {
+		t.Fatalf("unexpected LinkPolicyError: %v", policyErr)
+	}
+}
@@ -0,0 +1,104 @@
+package dagpb
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+// LinkSystemOptions holds the choices made by ConfigureLinkSystem.
+type LinkSystemOptions struct {
+	// Reifier is installed as the LinkSystem's NodeReifier, and so is applied
+	// to every node loaded. Leave it nil for plain PBNodes, use ReifyNameMap
+	// for a NameMap view, or a UnixFS reifier (such as Reify from
+	// github.com/ipfs/go-unixfsnode) for a UnixFS view.
+	Reifier ipld.NodeReifier
+
+	// TrustedStorage skips hash verification when loading. By default every
+	// block is hashed as it is loaded and checked against its CID.
+	TrustedStorage bool
+}
+
+// ConfigureLinkSystem sets up an existing LinkSystem for DAG-PB data with raw
+// (0x55) leaves. Encoding and decoding for both codecs is wired in directly,
+// ahead of whatever choosers the LinkSystem already has (the
+// cidlink.DefaultLinkSystem choosers are used where none are set), the
+// reifier from opts is installed and hash verification is enabled unless
+// opts.TrustedStorage is set. Storage is left untouched.
+//
+// The returned chooser picks Type.PBNode for DAG-PB links and a Bytes
+// prototype for raw links, falling back to basicnode.Prototype.Any, and is
+// intended for use as the LinkTargetNodePrototypeChooser of a
+// traversal.Config along with the LinkSystem.
+func ConfigureLinkSystem(lsys *ipld.LinkSystem, opts LinkSystemOptions) traversal.LinkTargetNodePrototypeChooser {
+	defaults := cidlink.DefaultLinkSystem()
+	if lsys.EncoderChooser == nil {
+		lsys.EncoderChooser = defaults.EncoderChooser
+	}
+	if lsys.DecoderChooser == nil {
+		lsys.DecoderChooser = defaults.DecoderChooser
+	}
+	if lsys.HasherChooser == nil {
+		lsys.HasherChooser = defaults.HasherChooser
+	}
+
+	existingEncoder := lsys.EncoderChooser
+	lsys.EncoderChooser = func(lp ipld.LinkPrototype) (ipld.Encoder, error) {
+		if lp, ok := lp.(cidlink.LinkPrototype); ok {
+			switch lp.Codec {
+			case cid.DagProtobuf:
+				return Encode, nil
+			case cid.Raw:
+				return raw.Encode, nil
+			}
+		}
+		return existingEncoder(lp)
+	}
+	existingDecoder := lsys.DecoderChooser
+	lsys.DecoderChooser = func(lnk ipld.Link) (ipld.Decoder, error) {
+		if lnk, ok := lnk.(cidlink.Link); ok {
+			switch lnk.Cid.Prefix().Codec {
+			case cid.DagProtobuf:
+				return Decode, nil
+			case cid.Raw:
+				return raw.Decode, nil
+			}
+		}
+		return existingDecoder(lnk)
+	}
+
+	lsys.NodeReifier = opts.Reifier
+	lsys.TrustedStorage = opts.TrustedStorage
+
+	return AddSupportToChooser(func(lnk ipld.Link, _ ipld.LinkContext) (ipld.NodePrototype, error) {
+		if lnk, ok := lnk.(cidlink.Link); ok && lnk.Cid.Prefix().Codec == cid.Raw {
+			return basicnode.Prototype.Bytes, nil
+		}
+		return basicnode.Prototype.Any, nil
+	})
+}
+
+// storeBlock writes already encoded bytes to the LinkSystem under the CID
+// made from them with prefix.
+func storeBlock(ctx context.Context, lsys *ipld.LinkSystem, prefix cid.Prefix, enc []byte) (cid.Cid, error) {
+	c, err := prefix.Sum(enc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	w, commit, err := lsys.StorageWriteOpener(ipld.LinkContext{Ctx: ctx})
+	if err != nil {
+		return cid.Undef, err
+	}
+	if _, err := w.Write(enc); err != nil {
+		return cid.Undef, err
+	}
+	if err := commit(cidlink.Link{Cid: c}); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
@@ -0,0 +1,77 @@
+package dagpb
+
+import (
+	"errors"
+	"testing"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+func TestConfigureLinkSystem(t *testing.T) {
+	lsys, store := mkLinkSystem()
+	chooser := ConfigureLinkSystem(lsys, LinkSystemOptions{})
+
+	leaf := storeRaw(t, lsys, []byte("leaf"))
+	root := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("leaf", leaf, 4)}})
+
+	proto, err := chooser(cidlink.Link{Cid: root}, ipld.LinkContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: root}, proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.(PBNode); !ok {
+		t.Fatalf("expected a PBNode without a reifier, got %T", n)
+	}
+
+	proto, err = chooser(cidlink.Link{Cid: leaf}, ipld.LinkContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: leaf}, proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byts, err := n.AsBytes(); err != nil || string(byts) != "leaf" {
+		t.Fatalf("unexpected raw leaf: %v %q", err, byts)
+	}
+
+	// corrupt the root block, loading should now fail verification
+	store.Bag[string(root.Bytes())] = []byte{0x0a, 0x00}
+	_, err = lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: root}, Type.PBNode)
+	if !errors.As(err, &ipld.ErrHashMismatch{}) {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+
+	ConfigureLinkSystem(lsys, LinkSystemOptions{TrustedStorage: true})
+	if _, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: root}, Type.PBNode); err != nil {
+		t.Fatalf("expected trusted storage to skip verification, got %v", err)
+	}
+}
+
+func TestConfigureLinkSystemNameMap(t *testing.T) {
+	lsys, _ := mkLinkSystem()
+	chooser := ConfigureLinkSystem(lsys, LinkSystemOptions{Reifier: ReifyNameMap})
+
+	leaf := storeRaw(t, lsys, []byte("leaf"))
+	dir := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("file", leaf, 4)}})
+	root := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("dir", dir, 60)}})
+
+	n, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: root}, Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := traversal.Progress{Cfg: &traversal.Config{LinkSystem: *lsys, LinkTargetNodePrototypeChooser: chooser}}
+	found, err := prog.Get(n, datamodel.ParsePath("dir/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byts, err := found.AsBytes(); err != nil || string(byts) != "leaf" {
+		t.Fatalf("unexpected leaf: %v %q", err, byts)
+	}
+}
@@ -19,6 +19,28 @@ type pbLink struct {
 	hasTsize bool
 }
 
+// toPBLink returns the fields of a PBLink that has been through
+// validatePBLink.
+func toPBLink(link PBLink) pbLink {
+	return pbLink{
+		hash:     link.Hash.x.(cidlink.Link).Cid,
+		name:     link.sortName(),
+		hasName:  link.Name.Exists(),
+		tsize:    uint64(link.Tsize.v.x),
+		hasTsize: link.Tsize.Exists(),
+	}
+}
+
+// EncodeOptions can be used to customize the behavior of encoding, through
+// its Encode and AppendEncode methods. The zero value encodes exactly as the
+// package-level Encode and AppendEncode do.
+type EncodeOptions struct {
+	// LinkPolicy, if set, is checked against the Hash of each link before
+	// encoding, and encoding fails with a *LinkPolicyError on the first link
+	// it does not allow.
+	LinkPolicy *LinkPolicy
+}
+
 // Encode provides an IPLD codec encode interface for DAG-PB data. Provide a
 // conforming Node and a destination for bytes to marshal a DAG-PB IPLD Node.
 // The Node must strictly conform to the DAG-PB schema
@@ -27,11 +49,16 @@ type pbLink struct {
 // This function is registered via the go-ipld-prime link loader for multicodec
 // code 0x70 when this package is invoked via init.
 func Encode(node ipld.Node, w io.Writer) error {
+	return EncodeOptions{}.Encode(node, w)
+}
+
+// Encode is like the package-level Encode, applying the options.
+func (opts EncodeOptions) Encode(node ipld.Node, w io.Writer) error {
 	// 1KiB can be allocated on the stack, and covers most small nodes
 	// without having to grow the buffer and cause allocations.
 	enc := make([]byte, 0, 1024)
 
-	enc, err := AppendEncode(enc, node)
+	enc, err := opts.AppendEncode(enc, node)
 	if err != nil {
 		return err
 	}
@@ -43,16 +70,47 @@ func Encode(node ipld.Node, w io.Writer) error {
 // This means less copying of bytes, and if the destination has enough capacity,
 // fewer allocations.
 func AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
+	return EncodeOptions{}.AppendEncode(enc, inNode)
+}
+
+// AppendEncode is like the package-level AppendEncode, applying the options.
+func (opts EncodeOptions) AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
+	if lazy, ok := asLazyNode(inNode); ok {
+		if opts.LinkPolicy == nil && lazy.canonical() {
+			return append(enc, lazy.src...), nil
+		}
+	}
+	enc, data, hasData, err := appendEncodeLinks(enc, inNode, opts.LinkPolicy)
+	if err != nil {
+		return enc, err
+	}
+	if hasData {
+		enc = appendDataHeader(enc, data)
+		enc = append(enc, data...)
+	}
+	return enc, nil
+}
+
+// appendEncodeLinks does the work of AppendEncode, except for the Data,
+// which is returned for the caller to append, or write elsewhere without
+// copying. Data is the last field of the encoded form. Link Hashes are
+// checked against policy, which may be nil.
+func appendEncodeLinks(enc []byte, inNode ipld.Node, policy *LinkPolicy) ([]byte, []byte, bool, error) {
+	if lazy, ok := asLazyNode(inNode); ok {
+		// the representation is assigned without the type-level wrapping
+		// of each field
+		inNode = lazy.Representation()
+	}
 	// Wrap in a typed node for some basic schema form checking
 	builder := Type.PBNode.NewBuilder()
 	if err := builder.AssignNode(inNode); err != nil {
-		return enc, err
+		return enc, nil, false, err
 	}
 	node := builder.Build()
 
 	links, err := node.LookupByString("Links")
 	if err != nil {
-		return enc, err
+		return enc, nil, false, err
 	}
 
 	if links.Length() > 0 {
@@ -63,23 +121,26 @@ func AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
 		for !linksIter.Done() {
 			ii, link, err := linksIter.Next()
 			if err != nil {
-				return enc, err
+				return enc, nil, false, err
 			}
 
 			{ // Hash (required)
 				d, err := link.LookupByString("Hash")
 				if err != nil {
-					return enc, err
+					return enc, nil, false, err
 				}
 				l, err := d.AsLink()
 				if err != nil {
-					return enc, err
+					return enc, nil, false, err
 				}
 				cl, ok := l.(cidlink.Link)
 				if !ok {
 					// this _should_ be taken care of by the Typed conversion above with
 					// "missing required fields: Hash"
-					return enc, fmt.Errorf("invalid DAG-PB form (link must have a Hash)")
+					return enc, nil, false, fmt.Errorf("invalid DAG-PB form (link must have a Hash)")
+				}
+				if err := policy.check(cl.Cid, int(ii)); err != nil {
+					return enc, nil, false, err
 				}
 				pbLinks[ii].hash = cl.Cid
 			}
@@ -87,12 +148,12 @@ func AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
 			{ // Name (optional)
 				nameNode, err := link.LookupByString("Name")
 				if err != nil {
-					return enc, err
+					return enc, nil, false, err
 				}
 				if !nameNode.IsAbsent() {
 					name, err := nameNode.AsString()
 					if err != nil {
-						return enc, err
+						return enc, nil, false, err
 					}
 					pbLinks[ii].name = name
 					pbLinks[ii].hasName = true
@@ -102,15 +163,15 @@ func AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
 			{ // Tsize (optional)
 				tsizeNode, err := link.LookupByString("Tsize")
 				if err != nil {
-					return enc, err
+					return enc, nil, false, err
 				}
 				if !tsizeNode.IsAbsent() {
 					tsize, err := tsizeNode.AsInt()
 					if err != nil {
-						return enc, err
+						return enc, nil, false, err
 					}
 					if tsize < 0 {
-						return enc, fmt.Errorf("Link has negative Tsize value [%v]", tsize)
+						return enc, nil, false, fmt.Errorf("Link has negative Tsize value [%v]", tsize)
 					}
 					utsize := uint64(tsize)
 					pbLinks[ii].tsize = utsize
@@ -123,51 +184,61 @@ func AppendEncode(enc []byte, inNode ipld.Node) ([]byte, error) {
 		// ordering where the names are the same (or absent)
 		sort.Stable(pbLinkSlice(pbLinks))
 		for _, link := range pbLinks {
-			hash := link.hash.Bytes()
-
-			size := 0
-			size += protowire.SizeTag(2)
-			size += protowire.SizeBytes(len(hash))
-			if link.hasName {
-				size += protowire.SizeTag(2)
-				size += protowire.SizeBytes(len(link.name))
-			}
-			if link.hasTsize {
-				size += protowire.SizeTag(3)
-				size += protowire.SizeVarint(uint64(link.tsize))
-			}
-
-			enc = protowire.AppendTag(enc, 2, 2) // field & wire type for Links
-			enc = protowire.AppendVarint(enc, uint64(size))
-
-			enc = protowire.AppendTag(enc, 1, 2) // field & wire type for Hash
-			enc = protowire.AppendBytes(enc, hash)
-			if link.hasName {
-				enc = protowire.AppendTag(enc, 2, 2) // field & wire type for Name
-				enc = protowire.AppendString(enc, link.name)
-			}
-			if link.hasTsize {
-				enc = protowire.AppendTag(enc, 3, 0) // field & wire type for Tsize
-				enc = protowire.AppendVarint(enc, uint64(link.tsize))
-			}
+			enc = appendLink(enc, link)
 		}
 	} // if links
 
 	// Data (optional)
 	data, err := node.LookupByString("Data")
 	if err != nil {
-		return enc, err
+		return enc, nil, false, err
 	}
-	if !data.IsAbsent() {
-		byts, err := data.AsBytes()
-		if err != nil {
-			return enc, err
-		}
-		enc = protowire.AppendTag(enc, 1, 2) // field & wire type for Data
-		enc = protowire.AppendBytes(enc, byts)
+	if data.IsAbsent() {
+		return enc, nil, false, nil
+	}
+	byts, err := data.AsBytes()
+	if err != nil {
+		return enc, nil, false, err
 	}
+	return enc, byts, true, nil
+}
+
+// appendLink appends link as one entry of the Links.
+func appendLink(enc []byte, link pbLink) []byte {
+	hash := link.hash.Bytes()
+
+	size := 0
+	size += protowire.SizeTag(2)
+	size += protowire.SizeBytes(len(hash))
+	if link.hasName {
+		size += protowire.SizeTag(2)
+		size += protowire.SizeBytes(len(link.name))
+	}
+	if link.hasTsize {
+		size += protowire.SizeTag(3)
+		size += protowire.SizeVarint(uint64(link.tsize))
+	}
+
+	enc = protowire.AppendTag(enc, 2, 2) // field & wire type for Links
+	enc = protowire.AppendVarint(enc, uint64(size))
+
+	enc = protowire.AppendTag(enc, 1, 2) // field & wire type for Hash
+	enc = protowire.AppendBytes(enc, hash)
+	if link.hasName {
+		enc = protowire.AppendTag(enc, 2, 2) // field & wire type for Name
+		enc = protowire.AppendString(enc, link.name)
+	}
+	if link.hasTsize {
+		enc = protowire.AppendTag(enc, 3, 0) // field & wire type for Tsize
+		enc = protowire.AppendVarint(enc, uint64(link.tsize))
+	}
+	return enc
+}
 
-	return enc, err
+// appendDataHeader appends the tag and length that precede the Data.
+func appendDataHeader(enc []byte, data []byte) []byte {
+	enc = protowire.AppendTag(enc, 1, 2) // field & wire type for Data
+	return protowire.AppendVarint(enc, uint64(len(data)))
 }
 
 type pbLinkSlice []pbLink
@@ -0,0 +1,302 @@
+package dagpb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrMergeConflict is returned by MergeFail, and so by Merge when using it,
+// wrapped in a *ConflictError.
+var ErrMergeConflict = fmt.Errorf("dagpb: merge conflict")
+
+// Conflict is a path changed differently on both sides of a Merge. Each of
+// Base, Ours and Theirs is nil where the path is absent on that side. The
+// roots themselves have an empty Path and links with only a Hash.
+type Conflict struct {
+	Path   string
+	Base   PBLink
+	Ours   PBLink
+	Theirs PBLink
+	// Resolved is the link chosen by the MergeStrategy, or nil where the
+	// path was left out of the merged tree.
+	Resolved PBLink
+}
+
+// ConflictError is returned by Merge when its MergeStrategy fails to resolve
+// a Conflict.
+type ConflictError struct {
+	Conflict Conflict
+	Err      error
+}
+
+func (e *ConflictError) Error() string {
+	path := e.Conflict.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s at %s", e.Err.Error(), path)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// MergeStrategy resolves a Conflict, returning the link to use for its path,
+// or nil to leave the path out. Returning one of the Conflict's links is
+// the usual resolution, a link returned with a different Name is given the
+// Name of the path. An error stops the Merge.
+type MergeStrategy func(Conflict) (PBLink, error)
+
+// MergeOurs resolves conflicts with our side of the Merge.
+func MergeOurs(c Conflict) (PBLink, error) {
+	return c.Ours, nil
+}
+
+// MergeTheirs resolves conflicts with their side of the Merge.
+func MergeTheirs(c Conflict) (PBLink, error) {
+	return c.Theirs, nil
+}
+
+// MergeFail does not resolve conflicts, so the Merge fails with the first
+// one found.
+func MergeFail(c Conflict) (PBLink, error) {
+	return nil, ErrMergeConflict
+}
+
+// MergeResult is the outcome of a Merge.
+type MergeResult struct {
+	// Root is the root of the merged tree.
+	Root cid.Cid
+	// Conflicts lists the conflicts found and how they were resolved, in
+	// the order they were found.
+	Conflicts []Conflict
+}
+
+// Merge performs a three-way merge of the trees under the roots ours and
+// theirs, which both descend from base, loading blocks from lsys and
+// storing the merged directories there.
+//
+// Directories are merged by link Name. A path changed on only one side
+// takes that change; a path changed the same way on both sides takes it
+// once. Where a path was changed differently on both sides, and both are
+// directories that can be merged in turn (a UnixFS directory or a plain
+// DAG-PB node with distinct, non-empty link Names, as for DiffDAG, whose
+// Data has not itself been changed differently), the merge descends into
+// them. Anything else is a Conflict, which is passed to strategy.
+//
+// Merged directories are encoded canonically, as by AppendEncode, with the
+// CID prefix of ours, and are given a Tsize of their encoded size plus the
+// Tsizes of their links. UnixFS HAMT sharded directories are not merged
+// entry by entry, and will conflict where both sides change them.
+func Merge(ctx context.Context, lsys *ipld.LinkSystem, base, ours, theirs cid.Cid, strategy MergeStrategy) (*MergeResult, error) {
+	if lsys.StorageWriteOpener == nil {
+		return nil, fmt.Errorf("dagpb: merge requires a LinkSystem with write storage")
+	}
+	m := &merger{ctx: ctx, lsys: lsys, strategy: strategy, prefix: ours.Prefix(), result: &MergeResult{}}
+	if m.prefix.Codec != cid.DagProtobuf {
+		m.prefix = cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}
+	}
+	rootLink := func(c cid.Cid) PBLink {
+		if !c.Defined() {
+			return nil
+		}
+		l := newPBLink(c, "", false, 0, false)
+		return &l
+	}
+	link, err := m.merge("", rootLink(base), rootLink(ours), rootLink(theirs))
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, fmt.Errorf("dagpb: merge removed the root")
+	}
+	m.result.Root = linkCid(link)
+	return m.result, nil
+}
+
+type merger struct {
+	ctx      context.Context
+	lsys     *ipld.LinkSystem
+	strategy MergeStrategy
+	prefix   cid.Prefix
+	result   *MergeResult
+}
+
+// merge returns the merged link for a path, or nil where it's left out.
+func (m *merger) merge(path string, base, ours, theirs PBLink) (PBLink, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case mergeLinkEqual(ours, theirs), mergeLinkEqual(base, theirs):
+		return ours, nil
+	case mergeLinkEqual(base, ours):
+		return theirs, nil
+	}
+
+	if ours != nil && theirs != nil {
+		merged, ok, err := m.mergeDirs(path, base, ours, theirs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return merged, nil
+		}
+	}
+
+	conflict := Conflict{Path: path, Base: base, Ours: ours, Theirs: theirs}
+	resolved, err := m.strategy(conflict)
+	if err != nil {
+		return nil, &ConflictError{Conflict: conflict, Err: err}
+	}
+	if resolved != nil {
+		if err := validatePBLink(resolved); err != nil {
+			return nil, err
+		}
+		if name := conflictName(conflict); resolved.Name != name {
+			l := *resolved
+			l.Name = name
+			resolved = &l
+		}
+	}
+	conflict.Resolved = resolved
+	m.result.Conflicts = append(m.result.Conflicts, conflict)
+	return resolved, nil
+}
+
+// mergeDirs merges two directories, returning false where they can't be
+// merged by entry.
+func (m *merger) mergeDirs(path string, base, ours, theirs PBLink) (PBLink, bool, error) {
+	oursDir, err := m.loadDir(ours)
+	if err != nil || oursDir == nil {
+		return nil, false, err
+	}
+	theirsDir, err := m.loadDir(theirs)
+	if err != nil || theirsDir == nil {
+		return nil, false, err
+	}
+	var baseNode PBNode
+	if base != nil {
+		baseDir, err := m.loadDir(base)
+		if err != nil || baseDir == nil {
+			return nil, false, err
+		}
+		baseNode = baseDir.node
+	} else {
+		baseNode = newPBNode(nil, nil, false)
+	}
+
+	data, ok := mergeData(baseNode, oursDir.node, theirsDir.node)
+	if !ok {
+		return nil, false, nil
+	}
+
+	baseLinks, oursLinks, theirsLinks := linksByName(baseNode), linksByName(oursDir.node), linksByName(theirsDir.node)
+	names := make([]string, 0, len(oursLinks)+len(theirsLinks))
+	for _, links := range []map[string]PBLink{baseLinks, oursLinks, theirsLinks} {
+		for name := range links {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var links []_PBLink
+	tsize := int64(0)
+	for ii, name := range names {
+		if ii > 0 && names[ii-1] == name {
+			continue
+		}
+		childPath := name
+		if path != "" {
+			childPath = path + "/" + name
+		}
+		l, err := m.merge(childPath, baseLinks[name], oursLinks[name], theirsLinks[name])
+		if err != nil {
+			return nil, false, err
+		}
+		if l != nil {
+			links = append(links, *l)
+			tsize += l.Tsize.v.x
+		}
+	}
+	if links == nil {
+		links = []_PBLink{}
+	}
+
+	c, size, err := m.store(newPBNode(links, maybeData(data), data.Data.Exists()))
+	if err != nil {
+		return nil, false, err
+	}
+	l := newPBLink(c, "", false, int64(size)+tsize, true)
+	l.Name = ours.Name
+	return &l, true, nil
+}
+
+func (m *merger) loadDir(l PBLink) (*dagDir, error) {
+	dir, err := loadDagDir(m.ctx, m.lsys, linkCid(l))
+	if err != nil || dir == nil || dir.hamt {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// store writes a merged directory to the LinkSystem, returning its CID and
+// encoded size.
+func (m *merger) store(node PBNode) (cid.Cid, int, error) {
+	enc, err := AppendEncode(nil, node)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	c, err := storeBlock(m.ctx, m.lsys, m.prefix, enc)
+	return c, len(enc), err
+}
+
+// mergeData returns the node whose Data should be used for a merged
+// directory, or false where both sides changed it differently.
+func mergeData(base, ours, theirs PBNode) (PBNode, bool) {
+	switch {
+	case dataEqual(ours, theirs), dataEqual(base, theirs):
+		return ours, true
+	case dataEqual(base, ours):
+		return theirs, true
+	}
+	return nil, false
+}
+
+func dataEqual(a, b PBNode) bool {
+	return a.Data.Exists() == b.Data.Exists() && bytes.Equal(a.Data.v.x, b.Data.v.x)
+}
+
+// conflictName returns the Name of the path of a Conflict, as found on its
+// links.
+func conflictName(c Conflict) _String__Maybe {
+	for _, l := range []PBLink{c.Ours, c.Theirs, c.Base} {
+		if l != nil {
+			return l.Name
+		}
+	}
+	return _String__Maybe{m: schema.Maybe_Absent}
+}
+
+func linksByName(node PBNode) map[string]PBLink {
+	links := make(map[string]PBLink, len(node.Links.x))
+	for ii := range node.Links.x {
+		links[node.Links.x[ii].sortName()] = &node.Links.x[ii]
+	}
+	return links
+}
+
+// mergeLinkEqual compares links where either may be absent.
+func mergeLinkEqual(a, b PBLink) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return pbLinkEqual(a, b)
+}
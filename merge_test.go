@@ -0,0 +1,103 @@
+package dagpb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+func TestMerge(t *testing.T) {
+	lsys, store := mkLinkSystem()
+	dir := func(links ...pbLink) cid.Cid { return storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: links}) }
+	file := func(s string) cid.Cid { return storeRaw(t, lsys, []byte(s)) }
+
+	base := dir(
+		namedLink("a", file("a"), 1),
+		namedLink("b", dir(namedLink("x", file("x"), 1)), 50),
+		namedLink("c", file("c"), 1),
+		namedLink("d", file("d"), 1),
+	)
+	ours := dir(
+		namedLink("a", file("a2"), 2),
+		namedLink("b", dir(namedLink("x", file("x"), 1), namedLink("y", file("y"), 1)), 100),
+		namedLink("c", file("c"), 1),
+		namedLink("d", file("d-ours"), 6),
+	)
+	theirs := dir(
+		namedLink("a", file("a"), 1),
+		namedLink("b", dir(namedLink("x", file("x"), 1), namedLink("z", file("z"), 1)), 100),
+		namedLink("d", file("d-theirs"), 8),
+	)
+
+	for _, tc := range []struct {
+		name     string
+		strategy MergeStrategy
+		d        cid.Cid
+	}{
+		{"ours", MergeOurs, file("d-ours")},
+		{"theirs", MergeTheirs, file("d-theirs")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Merge(context.Background(), lsys, base, ours, theirs, tc.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Conflicts) != 1 || result.Conflicts[0].Path != "d" || linkCid(result.Conflicts[0].Resolved) != tc.d {
+				t.Fatalf("unexpected conflicts: %+v", result.Conflicts)
+			}
+
+			expected := dir(
+				namedLink("a", file("a2"), 2),
+				namedLink("b", dir(namedLink("x", file("x"), 1), namedLink("y", file("y"), 1), namedLink("z", file("z"), 1)), 0),
+				namedLink("d", tc.d, 0),
+			)
+			if changes := diffDAG(t, lsys, expected, result.Root); changes != "/ changed" {
+				// only the Tsizes of b and d should differ
+				t.Fatalf("unexpected merge result: %s", changes)
+			}
+
+			// the merged blocks are stored canonically, with recomputed Tsizes
+			root := loadTestNode(t, store.Bag, result.Root)
+			if !bytes.Equal(mustEncode(t, root), store.Bag[string(result.Root.KeyString())]) {
+				t.Fatal("merged root is not canonical")
+			}
+			b := root.FieldLinks().Lookup(1)
+			bCid := b.FieldHash().Link().(cidlink.Link).Cid
+			if size := int64(len(store.Bag[string(bCid.KeyString())]) + 3); b.FieldTsize().Must().Int() != size {
+				t.Fatalf("expected a Tsize of %d for b, got %d", size, b.FieldTsize().Must().Int())
+			}
+		})
+	}
+
+	_, err := Merge(context.Background(), lsys, base, ours, theirs, MergeFail)
+	var conflictErr *ConflictError
+	if !errors.Is(err, ErrMergeConflict) || !errors.As(err, &conflictErr) {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+	if c := conflictErr.Conflict; c.Path != "d" || linkCid(c.Base) != file("d") || linkCid(c.Ours) != file("d-ours") || linkCid(c.Theirs) != file("d-theirs") {
+		t.Fatalf("unexpected conflict: %+v", c)
+	}
+	if !strings.HasSuffix(err.Error(), "at d") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// without conflicts, nothing is passed to the strategy
+	result, err := Merge(context.Background(), lsys, base, ours, base, MergeFail)
+	if err != nil || result.Root != ours {
+		t.Fatalf("expected ours to win a one-sided merge, got %v %v", result, err)
+	}
+}
+
+func loadTestNode(t *testing.T, bag map[string][]byte, c cid.Cid) PBNode {
+	t.Helper()
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, bag[string(c.KeyString())]); err != nil {
+		t.Fatal(err)
+	}
+	return nb.Build().(PBNode)
+}
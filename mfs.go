@@ -0,0 +1,440 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unixfsDirectoryData is the Data of an empty UnixFS directory.
+var unixfsDirectoryData = []byte{0x08, unixfsDirectory}
+
+const (
+	// mfsChunkSize is the largest file stored as a single raw block, and the
+	// size of the raw leaves of larger files, as for the IPFS importer.
+	mfsChunkSize = 256 << 10
+	// mfsMaxLinks is the most links in each UnixFS file node of a chunked
+	// file.
+	mfsMaxLinks = 174
+)
+
+// MutableTree is an in-memory, mutable view of a directory tree stored as
+// DAG-PB, in the manner of the IPFS MFS. Directories are loaded from the
+// LinkSystem as paths reach them, and changes are held in memory until
+// Flush, which encodes and stores only the directories that changed.
+//
+// Paths are link Names separated by "/", relative to the root; leading and
+// trailing slashes are ignored and "" is the root itself. Directories are
+// UnixFS directories or plain DAG-PB nodes with distinct, non-empty link
+// Names, as for DiffDAG. UnixFS HAMT sharded directories can be held in the
+// tree, but not changed.
+//
+// A MutableTree is not safe for concurrent use.
+type MutableTree struct {
+	ctx        context.Context
+	lsys       *ipld.LinkSystem
+	root       *mutableDir
+	rootCid    cid.Cid
+	dirPrefix  cid.Prefix
+	filePrefix cid.Prefix
+}
+
+type mutableDir struct {
+	data    []byte
+	hasData bool
+	entries map[string]*mutableEntry
+	// dirty is set where this directory, or any below it, has changed
+	// since it was loaded or flushed.
+	dirty bool
+}
+
+// mutableEntry is a link in a mutableDir. Its link is as loaded or last
+// flushed, and may be out of date where the entry holds a dirty directory or
+// unflushed file contents.
+type mutableEntry struct {
+	link    _PBLink
+	dir     *mutableDir
+	file    []byte
+	hasFile bool
+}
+
+// NewMutableTree returns a MutableTree of the directory root, or of a new,
+// empty UnixFS directory if root is cid.Undef. Directories are stored with
+// the CID prefix of root, or as CIDv1 with SHA2-256 where root is undefined
+// or not DAG-PB. Files of up to 256 KiB are stored as single raw (0x55)
+// CIDv1 blocks, using the same hash function; larger files are chunked into
+// such blocks of 256 KiB, linked from a tree of UnixFS file nodes stored as
+// the directories are, so that no block is too large to exchange.
+func NewMutableTree(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid) (*MutableTree, error) {
+	t := &MutableTree{
+		ctx:       ctx,
+		lsys:      lsys,
+		dirPrefix: cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1},
+	}
+	if root.Defined() && root.Prefix().Codec == cid.DagProtobuf {
+		t.dirPrefix = root.Prefix()
+	}
+	t.filePrefix = cid.Prefix{Version: 1, Codec: cid.Raw, MhType: t.dirPrefix.MhType, MhLength: t.dirPrefix.MhLength}
+
+	if !root.Defined() {
+		t.root = newMutableDir()
+		return t, nil
+	}
+	dir, err := t.loadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		return nil, fmt.Errorf("dagpb: %s is not a directory", root)
+	}
+	t.root = dir
+	t.rootCid = root
+	return t, nil
+}
+
+// Mkdir creates an empty UnixFS directory at path, along with any missing
+// parents. ErrLinkExists is returned if path already exists.
+func (t *MutableTree) Mkdir(path string) error {
+	parts, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: %q", ErrLinkExists, path)
+	}
+	chain, err := t.walk(parts[:len(parts)-1], true)
+	if err != nil {
+		return err
+	}
+	parent, name := chain[len(chain)-1], parts[len(parts)-1]
+	if _, ok := parent.entries[name]; ok {
+		return fmt.Errorf("%w: %q", ErrLinkExists, path)
+	}
+	parent.entries[name] = &mutableEntry{dir: newMutableDir()}
+	markDirty(chain)
+	return nil
+}
+
+// WriteFile sets the contents of the file at path, replacing any existing
+// file. The parent directory must exist. The contents are not copied, and
+// are stored when the tree is flushed.
+func (t *MutableTree) WriteFile(path string, data []byte) error {
+	parts, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("dagpb: cannot write a file at the root")
+	}
+	chain, err := t.walk(parts[:len(parts)-1], false)
+	if err != nil {
+		return err
+	}
+	parent, name := chain[len(chain)-1], parts[len(parts)-1]
+	if e, ok := parent.entries[name]; ok {
+		if isDir, err := t.isDir(e); err != nil {
+			return err
+		} else if isDir {
+			return fmt.Errorf("dagpb: %q is a directory", path)
+		}
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	parent.entries[name] = &mutableEntry{file: data, hasFile: true}
+	markDirty(chain)
+	return nil
+}
+
+// Move moves the file or directory at from to the path to, whose parent
+// must exist. ErrLinkExists is returned if to already exists.
+func (t *MutableTree) Move(from, to string) error {
+	fromParts, err := splitTreePath(from)
+	if err != nil {
+		return err
+	}
+	toParts, err := splitTreePath(to)
+	if err != nil {
+		return err
+	}
+	if len(fromParts) == 0 || len(toParts) == 0 {
+		return fmt.Errorf("dagpb: cannot move the root")
+	}
+	if len(toParts) > len(fromParts) && strings.Join(toParts[:len(fromParts)], "/") == strings.Join(fromParts, "/") {
+		return fmt.Errorf("dagpb: cannot move %q inside itself", from)
+	}
+
+	fromChain, err := t.walk(fromParts[:len(fromParts)-1], false)
+	if err != nil {
+		return err
+	}
+	fromParent, fromName := fromChain[len(fromChain)-1], fromParts[len(fromParts)-1]
+	e, ok := fromParent.entries[fromName]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrLinkNotFound, from)
+	}
+	toChain, err := t.walk(toParts[:len(toParts)-1], false)
+	if err != nil {
+		return err
+	}
+	toParent, toName := toChain[len(toChain)-1], toParts[len(toParts)-1]
+	if _, ok := toParent.entries[toName]; ok {
+		return fmt.Errorf("%w: %q", ErrLinkExists, to)
+	}
+
+	delete(fromParent.entries, fromName)
+	toParent.entries[toName] = e
+	markDirty(fromChain)
+	markDirty(toChain)
+	return nil
+}
+
+// Remove removes the file or directory at path, and everything under it.
+func (t *MutableTree) Remove(path string) error {
+	parts, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("dagpb: cannot remove the root")
+	}
+	chain, err := t.walk(parts[:len(parts)-1], false)
+	if err != nil {
+		return err
+	}
+	parent, name := chain[len(chain)-1], parts[len(parts)-1]
+	if _, ok := parent.entries[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrLinkNotFound, path)
+	}
+	delete(parent.entries, name)
+	markDirty(chain)
+	return nil
+}
+
+// Flush stores the files written and directories changed since the last
+// Flush, bottom-up, and returns the CID of the root. Directories are
+// encoded canonically, as by AppendEncode, and the links to them are given a
+// Tsize of their encoded size plus the Tsizes of their links, as are the
+// links to chunked files; single block files are given a Tsize of their
+// length. Nothing is stored where nothing changed.
+func (t *MutableTree) Flush() (cid.Cid, error) {
+	if !t.root.dirty {
+		return t.rootCid, nil
+	}
+	if t.lsys.StorageWriteOpener == nil {
+		return cid.Undef, fmt.Errorf("dagpb: flush requires a LinkSystem with write storage")
+	}
+	c, _, err := t.flush(t.root)
+	if err != nil {
+		return cid.Undef, err
+	}
+	t.rootCid = c
+	return c, nil
+}
+
+func (t *MutableTree) flush(dir *mutableDir) (cid.Cid, int64, error) {
+	names := make([]string, 0, len(dir.entries))
+	for name := range dir.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	links := make([]_PBLink, 0, len(names))
+	var tsize int64
+	for _, name := range names {
+		e := dir.entries[name]
+		switch {
+		case e.hasFile:
+			c, size, err := t.storeFile(e.file)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			e.link = newPBLink(c, name, true, size, true)
+			e.file, e.hasFile = nil, false
+		case e.dir != nil && e.dir.dirty:
+			c, size, err := t.flush(e.dir)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			e.link = newPBLink(c, name, true, size, true)
+		case e.link.sortName() != name:
+			// moved, and otherwise unchanged
+			e.link.Name = _String__Maybe{m: schema.Maybe_Value, v: _String{x: name}}
+		}
+		links = append(links, e.link)
+		tsize += e.link.Tsize.v.x
+	}
+
+	enc, err := AppendEncode(nil, newPBNode(links, dir.data, dir.hasData))
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	c, err := storeBlock(t.ctx, t.lsys, t.dirPrefix, enc)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	dir.dirty = false
+	return c, int64(len(enc)) + tsize, nil
+}
+
+// storeFile stores the contents of a file, chunked where it is larger than
+// mfsChunkSize, and returns its CID and Tsize.
+func (t *MutableTree) storeFile(data []byte) (cid.Cid, int64, error) {
+	if len(data) <= mfsChunkSize {
+		c, err := storeBlock(t.ctx, t.lsys, t.filePrefix, data)
+		return c, int64(len(data)), err
+	}
+
+	// Each entry is a link to part of the file and the length of that part.
+	type filePart struct {
+		link     _PBLink
+		filesize uint64
+	}
+	parts := make([]filePart, 0, (len(data)+mfsChunkSize-1)/mfsChunkSize)
+	for off := 0; off < len(data); off += mfsChunkSize {
+		chunk := data[off:min(off+mfsChunkSize, len(data))]
+		c, err := storeBlock(t.ctx, t.lsys, t.filePrefix, chunk)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		parts = append(parts, filePart{newPBLink(c, "", true, int64(len(chunk)), true), uint64(len(chunk))})
+	}
+	for len(parts) > 1 {
+		next := make([]filePart, 0, (len(parts)+mfsMaxLinks-1)/mfsMaxLinks)
+		for off := 0; off < len(parts); off += mfsMaxLinks {
+			group := parts[off:min(off+mfsMaxLinks, len(parts))]
+			links := make([]_PBLink, len(group))
+			blocksizes := make([]uint64, len(group))
+			var filesize uint64
+			var tsize int64
+			for ii, part := range group {
+				links[ii] = part.link
+				blocksizes[ii] = part.filesize
+				filesize += part.filesize
+				tsize += part.link.Tsize.v.x
+			}
+			enc, err := AppendEncode(nil, newPBNode(links, appendUnixFSFileData(nil, filesize, blocksizes), true))
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			c, err := storeBlock(t.ctx, t.lsys, t.dirPrefix, enc)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			next = append(next, filePart{newPBLink(c, "", true, int64(len(enc))+tsize, true), filesize})
+		}
+		parts = next
+	}
+	return linkCid(&parts[0].link), parts[0].link.Tsize.v.x, nil
+}
+
+// appendUnixFSFileData appends the UnixFS Data of a file node with the given
+// size, whose links are to parts of the given sizes.
+func appendUnixFSFileData(enc []byte, filesize uint64, blocksizes []uint64) []byte {
+	enc = protowire.AppendTag(enc, 1, protowire.VarintType)
+	enc = protowire.AppendVarint(enc, unixfsFile)
+	enc = protowire.AppendTag(enc, 3, protowire.VarintType)
+	enc = protowire.AppendVarint(enc, filesize)
+	for _, size := range blocksizes {
+		enc = protowire.AppendTag(enc, 4, protowire.VarintType)
+		enc = protowire.AppendVarint(enc, size)
+	}
+	return enc
+}
+
+// walk returns the directories from the root down to the one at parts,
+// loading them as needed, and creating any missing where create is set.
+func (t *MutableTree) walk(parts []string, create bool) ([]*mutableDir, error) {
+	if t.root.entries == nil {
+		return nil, fmt.Errorf("dagpb: the root is a sharded directory, which cannot be changed")
+	}
+	chain := []*mutableDir{t.root}
+	dir := t.root
+	for ii, name := range parts {
+		path := strings.Join(parts[:ii+1], "/")
+		e, ok := dir.entries[name]
+		if !ok {
+			if !create {
+				return nil, fmt.Errorf("%w: %q", ErrLinkNotFound, path)
+			}
+			e = &mutableEntry{dir: newMutableDir()}
+			dir.entries[name] = e
+		}
+		if isDir, err := t.isDir(e); err != nil {
+			return nil, err
+		} else if !isDir {
+			return nil, fmt.Errorf("dagpb: %q is not a directory", path)
+		}
+		if e.dir.entries == nil {
+			return nil, fmt.Errorf("dagpb: %q is a sharded directory, which cannot be changed", path)
+		}
+		dir = e.dir
+		chain = append(chain, dir)
+	}
+	return chain, nil
+}
+
+// isDir returns true if the entry is a directory, loading it if needed.
+func (t *MutableTree) isDir(e *mutableEntry) (bool, error) {
+	if e.dir != nil {
+		return true, nil
+	}
+	if e.hasFile {
+		return false, nil
+	}
+	dir, err := t.loadDir(linkCid(&e.link))
+	if err != nil || dir == nil {
+		return false, err
+	}
+	e.dir = dir
+	return true, nil
+}
+
+// loadDir loads a directory, returning nil if c is not one. HAMT sharded
+// directories are returned without entries.
+func (t *MutableTree) loadDir(c cid.Cid) (*mutableDir, error) {
+	d, err := loadDagDir(t.ctx, t.lsys, c)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	dir := &mutableDir{data: maybeData(d.node), hasData: d.node.Data.Exists()}
+	if d.hamt {
+		return dir, nil
+	}
+	dir.entries = make(map[string]*mutableEntry, len(d.node.Links.x))
+	for ii := range d.node.Links.x {
+		dir.entries[d.node.Links.x[ii].sortName()] = &mutableEntry{link: d.node.Links.x[ii]}
+	}
+	return dir, nil
+}
+
+func newMutableDir() *mutableDir {
+	return &mutableDir{data: unixfsDirectoryData, hasData: true, entries: map[string]*mutableEntry{}, dirty: true}
+}
+
+func markDirty(chain []*mutableDir) {
+	for _, dir := range chain {
+		dir.dirty = true
+	}
+}
+
+func splitTreePath(path string) ([]string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, nil
+	}
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return nil, fmt.Errorf("dagpb: invalid path %q", path)
+		}
+	}
+	return parts, nil
+}
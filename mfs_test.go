@@ -0,0 +1,223 @@
+package dagpb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+)
+
+// recordStores counts the blocks stored through lsys
+func recordStores(lsys *ipld.LinkSystem) *int {
+	var stored int
+	open := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lc ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		stored++
+		return open(lc)
+	}
+	return &stored
+}
+
+func TestMutableTree(t *testing.T) {
+	ctx := context.Background()
+	lsys, store := mkLinkSystem()
+	dir := func(links ...pbLink) cid.Cid { return storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: links}) }
+	readme := storeRaw(t, lsys, []byte("readme"))
+	shared := dir(namedLink("x", storeRaw(t, lsys, []byte("x")), 1))
+	root := dir(
+		namedLink("docs", dir(namedLink("readme", readme, 6)), 60),
+		namedLink("shared", shared, 50),
+	)
+
+	tree, err := NewMutableTree(ctx, lsys, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Mkdir("new/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WriteFile("/new/sub/f.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Move("docs/readme", "readme"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := recordStores(lsys)
+	newRoot, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the root, docs, new, new/sub and f.txt
+	if *stored != 5 {
+		t.Fatalf("expected 5 blocks to be stored, got %d", *stored)
+	}
+
+	rootNode := loadTestNode(t, store.Bag, newRoot)
+	if !bytes.Equal(mustEncode(t, rootNode), store.Bag[string(newRoot.KeyString())]) {
+		t.Fatal("the flushed root is not canonical")
+	}
+	if names := linkNames(rootNode); len(names) != 4 || names[0] != "docs" || names[1] != "new" || names[2] != "readme" || names[3] != "shared" {
+		t.Fatalf("unexpected root links: %v", names)
+	}
+	if l := rootNode.FieldLinks().Lookup(2); linkCid(l) != readme || l.FieldTsize().Must().Int() != 6 {
+		t.Fatal("the moved link should keep its Hash and Tsize")
+	}
+	if l := rootNode.FieldLinks().Lookup(3); linkCid(l) != shared || l.FieldTsize().Must().Int() != 50 {
+		t.Fatal("the unchanged link should be kept")
+	}
+	newLink := rootNode.FieldLinks().Lookup(1)
+	newNode := loadTestNode(t, store.Bag, linkCid(newLink))
+	subLink := newNode.FieldLinks().Lookup(0)
+	subSize := int64(len(store.Bag[string(linkCid(subLink).KeyString())])) + 5
+	if subLink.FieldTsize().Must().Int() != subSize {
+		t.Fatalf("expected a Tsize of %d for new/sub, got %d", subSize, subLink.FieldTsize().Must().Int())
+	}
+	if size := int64(len(store.Bag[string(linkCid(newLink).KeyString())])) + subSize; newLink.FieldTsize().Must().Int() != size {
+		t.Fatalf("expected a Tsize of %d for new, got %d", size, newLink.FieldTsize().Must().Int())
+	}
+
+	// nothing changed, nothing stored
+	*stored = 0
+	if again, err := tree.Flush(); err != nil || again != newRoot || *stored != 0 {
+		t.Fatalf("expected an unchanged root without storing, got %v %v with %d stored", again, err, *stored)
+	}
+
+	// only the path to a change is stored again
+	if err := tree.Remove("new/sub/f.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if *stored != 3 {
+		t.Fatalf("expected 3 blocks to be stored, got %d", *stored)
+	}
+
+	// a tree reopened from the flushed root sees the same content
+	reopened, err := NewMutableTree(ctx, lsys, tree.rootCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Mkdir("new/sub"); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("expected ErrLinkExists, got %v", err)
+	}
+}
+
+func TestMutableTreeEmpty(t *testing.T) {
+	lsys, store := mkLinkSystem()
+	tree, err := NewMutableTree(context.Background(), lsys, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WriteFile("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Prefix().Version != 1 || root.Prefix().Codec != cid.DagProtobuf {
+		t.Fatalf("unexpected root CID %s", root)
+	}
+	node := loadTestNode(t, store.Bag, root)
+	if !bytes.Equal(node.FieldData().Must().Bytes(), []byte{8, 1}) {
+		t.Fatal("expected a UnixFS directory")
+	}
+	if c := linkCid(node.FieldLinks().Lookup(0)); c.Prefix().Codec != cid.Raw || len(store.Bag[string(c.KeyString())]) != 0 {
+		t.Fatal("expected an empty raw file")
+	}
+}
+
+func TestMutableTreeLargeFile(t *testing.T) {
+	lsys, store := mkLinkSystem()
+	tree, err := NewMutableTree(context.Background(), lsys, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*mfsChunkSize+100)
+	for ii := range data {
+		data[ii] = byte(ii * 7)
+	}
+	if err := tree.WriteFile("big", data); err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, blk := range store.Bag {
+		if len(blk) > mfsChunkSize+1024 {
+			t.Fatalf("block %x of %d bytes is too large", k, len(blk))
+		}
+	}
+
+	fileLink := loadTestNode(t, store.Bag, root).FieldLinks().Lookup(0)
+	file := loadTestNode(t, store.Bag, linkCid(fileLink))
+	if ud, err := parseUnixFSData(file.FieldData().Must().Bytes()); err != nil || ud.typ != unixfsFile {
+		t.Fatalf("expected a UnixFS file, got %v, %v", ud, err)
+	}
+	wantData := appendUnixFSFileData(nil, uint64(len(data)), []uint64{mfsChunkSize, mfsChunkSize, mfsChunkSize, 100})
+	if !bytes.Equal(file.FieldData().Must().Bytes(), wantData) {
+		t.Fatalf("unexpected UnixFS Data %x", file.FieldData().Must().Bytes())
+	}
+	var joined []byte
+	tsize := int64(len(store.Bag[string(linkCid(fileLink).KeyString())]))
+	for itr := file.FieldLinks().Iterator(); !itr.Done(); {
+		_, l := itr.Next()
+		c := linkCid(l)
+		if c.Prefix().Codec != cid.Raw {
+			t.Fatalf("expected a raw leaf, got %s", c)
+		}
+		joined = append(joined, store.Bag[string(c.KeyString())]...)
+		tsize += l.FieldTsize().Must().Int()
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("the leaves do not make up the file")
+	}
+	if fileLink.FieldTsize().Must().Int() != tsize {
+		t.Fatalf("expected a Tsize of %d, got %d", tsize, fileLink.FieldTsize().Must().Int())
+	}
+
+	// a chunked file is not mistaken for a directory
+	if err := tree.Mkdir("big/sub"); err == nil {
+		t.Fatal("expected an error making a directory under a file")
+	}
+}
+
+func TestMutableTreeErrors(t *testing.T) {
+	lsys, _ := mkLinkSystem()
+	tree, err := NewMutableTree(context.Background(), lsys, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Mkdir("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WriteFile("a/f", []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		is   error
+	}{
+		{"Mkdir existing", tree.Mkdir("a"), ErrLinkExists},
+		{"WriteFile without a parent", tree.WriteFile("x/f", nil), ErrLinkNotFound},
+		{"WriteFile over a directory", tree.WriteFile("a/b", nil), nil},
+		{"WriteFile under a file", tree.WriteFile("a/f/g", nil), nil},
+		{"Move inside itself", tree.Move("a", "a/b/a"), nil},
+		{"Move to an invalid path", tree.Move("a/f", "a/b/../f"), nil},
+		{"Move onto existing", tree.Move("a/f", "a/b"), ErrLinkExists},
+		{"Remove missing", tree.Remove("a/nope"), ErrLinkNotFound},
+		{"Remove the root", tree.Remove("/"), nil},
+	} {
+		if tc.err == nil || (tc.is != nil && !errors.Is(tc.err, tc.is)) {
+			t.Errorf("%s: unexpected error %v", tc.name, tc.err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package dagpb
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+// ErrLinkNotFound is returned when a link is looked up by a Name that the
+// PBNode does not have.
+var ErrLinkNotFound = fmt.Errorf("dagpb: no link with that Name")
+
+// ErrLinkExists is returned by WithLink when the PBNode already has a link
+// with the same Name.
+var ErrLinkExists = fmt.Errorf("dagpb: a link with that Name already exists")
+
+// The functions below derive a new PBNode from an existing one, leaving the
+// original untouched. The links of the input PBNode are expected to be in
+// canonical order (as produced by NodeBuilder, by these functions, or by
+// decoding canonical bytes) and the output keeps them that way, so it can be
+// encoded without further sorting. Link storage is shared between the input
+// and output wherever the change allows it.
+//
+// Where a Name is looked up, the first link with that Name is used.
+
+// WithLink returns a copy of node with link added in its sorted position.
+// Links with an empty or absent Name may be added any number of times, and
+// are placed after any others that sort the same, but if the link has a
+// Name that's already present ErrLinkExists is returned.
+func WithLink(node PBNode, link PBLink) (PBNode, error) {
+	if err := validatePBLink(link); err != nil {
+		return nil, err
+	}
+	links := node.Links.x
+	name := link.sortName()
+	if name != "" {
+		if _, ok := findLink(links, name); ok {
+			return nil, fmt.Errorf("%w: %q", ErrLinkExists, name)
+		}
+	}
+	ii := sortedInsertIndex(links, name)
+	newLinks := make([]_PBLink, 0, len(links)+1)
+	newLinks = append(newLinks, links[:ii]...)
+	newLinks = append(newLinks, *link)
+	newLinks = append(newLinks, links[ii:]...)
+	return withLinks(node, newLinks), nil
+}
+
+// WithoutLink returns a copy of node without the link with the given Name,
+// or ErrLinkNotFound if there is no such link.
+func WithoutLink(node PBNode, name string) (PBNode, error) {
+	links := node.Links.x
+	ii, ok := findLink(links, name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrLinkNotFound, name)
+	}
+	switch ii {
+	case 0:
+		return withLinks(node, links[1:]), nil
+	case len(links) - 1:
+		return withLinks(node, links[:ii:ii]), nil
+	}
+	newLinks := make([]_PBLink, 0, len(links)-1)
+	newLinks = append(newLinks, links[:ii]...)
+	newLinks = append(newLinks, links[ii+1:]...)
+	return withLinks(node, newLinks), nil
+}
+
+// ReplaceLink returns a copy of node with the link with the given Name
+// replaced by link, or ErrLinkNotFound if there is no such link. The
+// replacement may have a different Name, in which case it is moved to its
+// sorted position.
+func ReplaceLink(node PBNode, name string, link PBLink) (PBNode, error) {
+	if err := validatePBLink(link); err != nil {
+		return nil, err
+	}
+	links := node.Links.x
+	ii, ok := findLink(links, name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrLinkNotFound, name)
+	}
+	if link.sortName() == name {
+		newLinks := make([]_PBLink, len(links))
+		copy(newLinks, links)
+		newLinks[ii] = *link
+		return withLinks(node, newLinks), nil
+	}
+	removed, err := WithoutLink(node, name)
+	if err != nil {
+		return nil, err
+	}
+	return WithLink(removed, link)
+}
+
+// WithTsize returns a copy of node with the Tsize of the link with the given
+// Name set, or ErrLinkNotFound if there is no such link.
+func WithTsize(node PBNode, name string, tsize uint64) (PBNode, error) {
+	if tsize > math.MaxInt64 {
+		return nil, fmt.Errorf("Link has out of range Tsize value [%v]", tsize)
+	}
+	ii, ok := findLink(node.Links.x, name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrLinkNotFound, name)
+	}
+	newLinks := make([]_PBLink, len(node.Links.x))
+	copy(newLinks, node.Links.x)
+	newLinks[ii].Tsize = _Int__Maybe{m: schema.Maybe_Value, v: _Int{x: int64(tsize)}}
+	return withLinks(node, newLinks), nil
+}
+
+// WithData returns a copy of node with its Data set. The bytes are not
+// copied. Note that an empty Data is present in the encoded form, use
+// WithoutData to leave it out.
+func WithData(node PBNode, data []byte) PBNode {
+	if data == nil {
+		data = []byte{}
+	}
+	return newPBNode(node.Links.x, data, true)
+}
+
+// WithoutData returns a copy of node with no Data.
+func WithoutData(node PBNode) PBNode {
+	return newPBNode(node.Links.x, nil, false)
+}
+
+// withLinks returns a copy of node with the links replaced and the Data kept.
+func withLinks(node PBNode, links []_PBLink) PBNode {
+	n := *node
+	n.Links = _PBLinks{x: links}
+	return &n
+}
+
+// findLink returns the index of the first link with the given Name.
+func findLink(links []_PBLink, name string) (int, bool) {
+	for ii := range links {
+		if links[ii].sortName() == name {
+			return ii, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,158 @@
+package dagpb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func mustLink(t *testing.T, name string, c cid.Cid, tsize uint64) PBLink {
+	t.Helper()
+	link, err := MakePBLink(name, c, tsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+// linkNames lists the link Names of node, in order
+func linkNames(node PBNode) []string {
+	var names []string
+	itr := node.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, l := itr.Next()
+		names = append(names, l.sortName())
+	}
+	return names
+}
+
+func TestMutate(t *testing.T) {
+	c1 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	c2 := mkcid(t, "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V")
+
+	base, err := NewNodeBuilder().SetData([]byte{8, 1}).AddLink("b", c1, 10).AddLink("d", c1, 20).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseEnc := mustEncode(t, base)
+
+	for _, tc := range []struct {
+		name     string
+		mutate   func() (PBNode, error)
+		expected *NodeBuilder
+	}{
+		{
+			name:     "WithLink in the middle",
+			mutate:   func() (PBNode, error) { return WithLink(base, mustLink(t, "c", c2, 5)) },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("b", c1, 10).AddLink("c", c2, 5).AddLink("d", c1, 20),
+		},
+		{
+			name:     "WithLink at the start",
+			mutate:   func() (PBNode, error) { return WithLink(base, mustLink(t, "a", c2, 5)) },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("a", c2, 5).AddLink("b", c1, 10).AddLink("d", c1, 20),
+		},
+		{
+			name:     "WithoutLink",
+			mutate:   func() (PBNode, error) { return WithoutLink(base, "b") },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("d", c1, 20),
+		},
+		{
+			name:     "ReplaceLink",
+			mutate:   func() (PBNode, error) { return ReplaceLink(base, "d", mustLink(t, "d", c2, 30)) },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("b", c1, 10).AddLink("d", c2, 30),
+		},
+		{
+			name:     "ReplaceLink with a rename",
+			mutate:   func() (PBNode, error) { return ReplaceLink(base, "d", mustLink(t, "a", c2, 30)) },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("a", c2, 30).AddLink("b", c1, 10),
+		},
+		{
+			name:     "WithTsize",
+			mutate:   func() (PBNode, error) { return WithTsize(base, "b", 11) },
+			expected: NewNodeBuilder().SetData([]byte{8, 1}).AddLink("b", c1, 11).AddLink("d", c1, 20),
+		},
+		{
+			name:     "WithData",
+			mutate:   func() (PBNode, error) { return WithData(base, []byte("new")), nil },
+			expected: NewNodeBuilder().SetData([]byte("new")).AddLink("b", c1, 10).AddLink("d", c1, 20),
+		},
+		{
+			name:     "WithoutData",
+			mutate:   func() (PBNode, error) { return WithoutData(base), nil },
+			expected: NewNodeBuilder().AddLink("b", c1, 10).AddLink("d", c1, 20),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := tc.mutate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := tc.expected.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(mustEncode(t, node), mustEncode(t, expected)) {
+				t.Fatalf("unexpected result, links %v", linkNames(node))
+			}
+			// the links are already canonical, without AppendEncode's sorting
+			if names := linkNames(node); len(names) > 1 && names[0] > names[1] {
+				t.Fatalf("links are not sorted: %v", names)
+			}
+			if !bytes.Equal(mustEncode(t, base), baseEnc) {
+				t.Fatal("the original node was modified")
+			}
+		})
+	}
+}
+
+func TestMutateSharing(t *testing.T) {
+	base, err := NewNodeBuilder().AddLink("a", acid, 1).AddLink("b", acid, 2).AddLink("c", acid, 3).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := WithData(base, []byte{1}); &n.Links.x[0] != &base.Links.x[0] {
+		t.Fatal("WithData should share links")
+	}
+	if n, _ := WithoutLink(base, "a"); &n.Links.x[0] != &base.Links.x[1] {
+		t.Fatal("WithoutLink should share the remaining links when removing the first")
+	}
+	n, _ := WithoutLink(base, "c")
+	if &n.Links.x[0] != &base.Links.x[0] {
+		t.Fatal("WithoutLink should share the remaining links when removing the last")
+	}
+	// appending to the shared storage must not clobber the original
+	n, err = WithLink(n, mustLink(t, "d", acid, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Links.x[2].sortName() != "c" {
+		t.Fatal("the original node was modified")
+	}
+}
+
+func TestMutateErrors(t *testing.T) {
+	base, err := NewNodeBuilder().AddLink("a", acid, 1).AddLink("", acid, 2).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WithLink(base, mustLink(t, "a", acid, 1)); !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("expected ErrLinkExists, got %v", err)
+	}
+	if n, err := WithLink(base, mustLink(t, "", acid, 3)); err != nil || n.FieldLinks().Length() != 3 {
+		t.Fatalf("expected unnamed links to be added freely, got %v", err)
+	}
+	if _, err := WithoutLink(base, "nope"); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("expected ErrLinkNotFound, got %v", err)
+	}
+	if _, err := ReplaceLink(base, "nope", mustLink(t, "a", acid, 1)); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("expected ErrLinkNotFound, got %v", err)
+	}
+	if _, err := WithTsize(base, "nope", 1); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("expected ErrLinkNotFound, got %v", err)
+	}
+	if _, err := WithLink(base, nil); err == nil {
+		t.Fatal("expected an error for a nil link")
+	}
+}
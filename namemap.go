@@ -0,0 +1,209 @@
+package dagpb
+
+import (
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/mixins"
+)
+
+// NameMapValue selects what the values of a NameMap are.
+type NameMapValue uint8
+
+const (
+	// NameMapHash presents the Hash link of each PBLink as the map value.
+	// Traversals and selectors will follow these links, so pathing through a
+	// NameMap resolves to the linked node.
+	NameMapHash NameMapValue = iota
+	// NameMapPBLink presents each PBLink itself as the map value.
+	NameMapPBLink
+)
+
+var (
+	_ ipld.Node        = (*NameMap)(nil)
+	_ ipld.ADL         = (*NameMap)(nil)
+	_ ipld.NodeReifier = ReifyNameMap
+)
+
+// NameMap is an ADL which presents a PBNode as a map keyed by link Name,
+// rather than the list of links seen in the Data Model. This allows pathing
+// through a plain DAG-PB directory by name, e.g. "a/b/c" rather than
+// "Links/3/Hash/Links/0/Hash/...".
+//
+// Links without a Name, or with an empty Name, are not visible in the map.
+// Where more than one link has the same Name, only the first is visible.
+//
+// The PBNode's Data is not visible through a NameMap, use Substrate() to get
+// at it.
+type NameMap struct {
+	node   PBNode
+	values NameMapValue
+	keys   []int64          // index into node.Links of each visible link, in order
+	lookup map[string]int64 // Name to index into node.Links
+}
+
+// NewNameMap wraps a PBNode in a NameMap ADL, with values selected by the
+// NameMapValue argument.
+func NewNameMap(node PBNode, values NameMapValue) *NameMap {
+	links := node.FieldLinks()
+	nm := &NameMap{
+		node:   node,
+		values: values,
+		keys:   make([]int64, 0, links.Length()),
+		lookup: make(map[string]int64, links.Length()),
+	}
+	itr := links.Iterator()
+	for !itr.Done() {
+		ii, link := itr.Next()
+		if !link.FieldName().Exists() {
+			continue
+		}
+		name := link.FieldName().Must().String()
+		if name == "" {
+			continue
+		}
+		if _, ok := nm.lookup[name]; ok {
+			continue
+		}
+		nm.lookup[name] = ii
+		nm.keys = append(nm.keys, ii)
+	}
+	return nm
+}
+
+// ReifyNameMap is an ipld.NodeReifier which presents PBNodes as NameMap ADLs
+// with NameMapHash values. Nodes of any other type are returned unchanged.
+//
+// Use it as the NodeReifier of an ipld.LinkSystem, along with a prototype
+// chooser from AddSupportToChooser, so that loading a DAG-PB block yields a
+// NameMap and traversals can resolve paths by link Name. It may also be
+// registered in LinkSystem.KnownReifiers for use by selectors.
+func ReifyNameMap(_ ipld.LinkContext, n ipld.Node, _ *ipld.LinkSystem) (ipld.Node, error) {
+	if pbn, ok := n.(PBNode); ok {
+		return NewNameMap(pbn, NameMapHash), nil
+	}
+	return n, nil
+}
+
+// AddNameMapSupportToReifier takes an existing node reifier and subs in
+// ReifyNameMap for PBNodes. The existing reifier may be nil.
+func AddNameMapSupportToReifier(existing ipld.NodeReifier) ipld.NodeReifier {
+	return func(lnkCtx ipld.LinkContext, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+		if pbn, ok := n.(PBNode); ok {
+			return NewNameMap(pbn, NameMapHash), nil
+		}
+		if existing == nil {
+			return n, nil
+		}
+		return existing(lnkCtx, n, lsys)
+	}
+}
+
+// Substrate returns the PBNode this NameMap wraps.
+func (nm *NameMap) Substrate() ipld.Node {
+	return nm.node
+}
+
+func (nm *NameMap) value(ii int64) ipld.Node {
+	link := nm.node.FieldLinks().Lookup(ii)
+	if nm.values == NameMapPBLink {
+		return link
+	}
+	return link.FieldHash()
+}
+
+func (*NameMap) Kind() ipld.Kind {
+	return ipld.Kind_Map
+}
+
+func (nm *NameMap) LookupByString(key string) (ipld.Node, error) {
+	ii, ok := nm.lookup[key]
+	if !ok {
+		return nil, ipld.ErrNotExists{Segment: ipld.PathSegmentOfString(key)}
+	}
+	return nm.value(ii), nil
+}
+
+func (nm *NameMap) LookupByNode(key ipld.Node) (ipld.Node, error) {
+	ks, err := key.AsString()
+	if err != nil {
+		return nil, err
+	}
+	return nm.LookupByString(ks)
+}
+
+func (nm *NameMap) LookupByIndex(idx int64) (ipld.Node, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.LookupByIndex(idx)
+}
+
+func (nm *NameMap) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
+	return nm.LookupByString(seg.String())
+}
+
+func (nm *NameMap) MapIterator() ipld.MapIterator {
+	return &nameMapIterator{nm: nm}
+}
+
+func (*NameMap) ListIterator() ipld.ListIterator {
+	return nil
+}
+
+func (nm *NameMap) Length() int64 {
+	return int64(len(nm.keys))
+}
+
+func (*NameMap) IsAbsent() bool {
+	return false
+}
+
+func (*NameMap) IsNull() bool {
+	return false
+}
+
+func (*NameMap) AsBool() (bool, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsBool()
+}
+
+func (*NameMap) AsInt() (int64, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsInt()
+}
+
+func (*NameMap) AsFloat() (float64, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsFloat()
+}
+
+func (*NameMap) AsString() (string, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsString()
+}
+
+func (*NameMap) AsBytes() ([]byte, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsBytes()
+}
+
+func (*NameMap) AsLink() (ipld.Link, error) {
+	return mixins.Map{TypeName: "dagpb.NameMap"}.AsLink()
+}
+
+// Prototype returns a basic map prototype, a NameMap can't be built directly
+// from Data Model operations.
+func (*NameMap) Prototype() ipld.NodePrototype {
+	return basicnode.Prototype.Map
+}
+
+type nameMapIterator struct {
+	nm  *NameMap
+	idx int
+}
+
+func (itr *nameMapIterator) Next() (ipld.Node, ipld.Node, error) {
+	if itr.Done() {
+		return nil, nil, ipld.ErrIteratorOverread{}
+	}
+	ii := itr.nm.keys[itr.idx]
+	itr.idx++
+	link := itr.nm.node.FieldLinks().Lookup(ii)
+	return link.FieldName().Must(), itr.nm.value(ii), nil
+}
+
+func (itr *nameMapIterator) Done() bool {
+	return itr.idx >= len(itr.nm.keys)
+}
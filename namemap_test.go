@@ -0,0 +1,111 @@
+package dagpb
+
+import (
+	"testing"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+func TestNameMap(t *testing.T) {
+	node := mkPBNode(t, pbNode{
+		data: []byte{8, 1},
+		links: []pbLink{
+			namedLink("a", acid, 1),
+			namedLink("b", mkcid(t, "QmWDtUQj38YLW8v3q4A6LwPn4vYKEbuKWpgSm6bjKW6Xfe"), 2),
+			namedLink("b", acid, 3), // shadowed by the first "b"
+			{hash: acid},            // no Name, not visible
+			namedLink("", acid, 4),  // empty Name, not visible
+		},
+	})
+
+	nm := NewNameMap(node, NameMapHash)
+	if nm.Kind() != ipld.Kind_Map {
+		t.Fatal("NameMap should be a map")
+	}
+	if nm.Length() != 2 {
+		t.Fatalf("expected 2 entries, got %d", nm.Length())
+	}
+	if nm.Substrate() != node {
+		t.Fatal("Substrate should return the wrapped PBNode")
+	}
+
+	v, err := nm.LookupByString("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := v.AsLink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.(cidlink.Link).Cid.String() != "QmWDtUQj38YLW8v3q4A6LwPn4vYKEbuKWpgSm6bjKW6Xfe" {
+		t.Fatal("lookup of a duplicate Name should return the first link")
+	}
+	if _, err := nm.LookupByString("c"); err == nil {
+		t.Fatal("expected lookup of a missing Name to fail")
+	}
+
+	var keys []string
+	mi := nm.MapIterator()
+	for !mi.Done() {
+		k, _, err := mi.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, _ := k.AsString()
+		keys = append(keys, ks)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	nm = NewNameMap(node, NameMapPBLink)
+	v, err = nm.LookupByString("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsize, err := v.(PBLink).FieldTsize().Must().AsInt()
+	if err != nil || tsize != 1 {
+		t.Fatal("expected NameMapPBLink values to be the PBLink")
+	}
+}
+
+func TestNameMapFocus(t *testing.T) {
+	lsys, _ := mkLinkSystem()
+	lsys.NodeReifier = ReifyNameMap
+
+	leaf := storeRaw(t, lsys, []byte("hello"))
+	b := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("c", leaf, 5)}})
+	a := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("b", b, 50)}})
+	root := storeNode(t, lsys, pbNode{links: []pbLink{namedLink("a", a, 100), namedLink("z", leaf, 5)}})
+
+	chooser := AddSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+	rootNode, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: root}, Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rootNode.(*NameMap); !ok {
+		t.Fatalf("expected the reifier to produce a NameMap, got %T", rootNode)
+	}
+
+	prog := traversal.Progress{Cfg: &traversal.Config{LinkSystem: *lsys, LinkTargetNodePrototypeChooser: chooser}}
+	var found ipld.Node
+	if err := prog.Focus(rootNode, datamodel.ParsePath("a/b/c"), func(_ traversal.Progress, n ipld.Node) error {
+		found = n
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	byts, err := found.AsBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(byts) != "hello" {
+		t.Fatalf("unexpected leaf contents: %q", byts)
+	}
+}
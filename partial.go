@@ -0,0 +1,66 @@
+package dagpb
+
+import "fmt"
+
+// ErrSkipped is returned for a part of a PartialNode that was skipped in
+// decoding.
+var ErrSkipped = fmt.Errorf("dagpb: part of the node was skipped in decoding")
+
+// PartialNode is a PBNode decoded by DecodePartial, which records which parts
+// were skipped, so that a skipped part is not mistaken for an absent Data or
+// an empty list of Links.
+type PartialNode struct {
+	node         PBNode
+	dataSkipped  bool
+	linksSkipped bool
+}
+
+// DecodePartial decodes the block in src, skipping the parts as set by
+// SkipData and SkipLinks.
+func (opts DecodeOptions) DecodePartial(src []byte) (*PartialNode, error) {
+	nb := Type.PBNode.NewBuilder()
+	ma, err := nb.BeginMap(2)
+	if err != nil {
+		return nil, err
+	}
+	// The Links are assembled as an empty list where skipped, as the PBNode
+	// type requires them; PartialNode keeps that from being seen.
+	if err := opts.visit(src, &assemblingVisitor{ma: ma}); err != nil {
+		return nil, err
+	}
+	return &PartialNode{node: nb.Build().(PBNode), dataSkipped: opts.SkipData, linksSkipped: opts.SkipLinks}, nil
+}
+
+// DataSkipped reports whether the Data was skipped.
+func (p *PartialNode) DataSkipped() bool {
+	return p.dataSkipped
+}
+
+// LinksSkipped reports whether the Links were skipped.
+func (p *PartialNode) LinksSkipped() bool {
+	return p.linksSkipped
+}
+
+// FieldData returns the Data, or ErrSkipped where it was skipped.
+func (p *PartialNode) FieldData() (MaybeBytes, error) {
+	if p.dataSkipped {
+		return nil, ErrSkipped
+	}
+	return p.node.FieldData(), nil
+}
+
+// FieldLinks returns the Links, or ErrSkipped where they were skipped.
+func (p *PartialNode) FieldLinks() (PBLinks, error) {
+	if p.linksSkipped {
+		return nil, ErrSkipped
+	}
+	return p.node.FieldLinks(), nil
+}
+
+// Node returns the whole PBNode, or ErrSkipped where any part was skipped.
+func (p *PartialNode) Node() (PBNode, error) {
+	if p.dataSkipped || p.linksSkipped {
+		return nil, ErrSkipped
+	}
+	return p.node, nil
+}
@@ -0,0 +1,97 @@
+package dagpb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+func TestDecodePartial(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	node := NewNodeBuilder().SetData([]byte("data")).AddLink("a", c, 1).AddLink("b", c, 2).mustBuild(t)
+	src := mustEncode(t, node)
+
+	whole, err := DecodeOptions{}.DecodePartial(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := whole.Node(); err != nil || !bytes.Equal(mustEncode(t, n), src) {
+		t.Fatalf("expected the whole node, got %v", err)
+	}
+
+	dataOnly, err := DecodeOptions{SkipLinks: true}.DecodePartial(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataOnly.FieldLinks(); !errors.Is(err, ErrSkipped) || !dataOnly.LinksSkipped() {
+		t.Fatalf("expected the Links skipped, got %v", err)
+	}
+	if data, err := dataOnly.FieldData(); err != nil || string(data.Must().Bytes()) != "data" {
+		t.Fatalf("unexpected Data, %v", err)
+	}
+	if _, err := dataOnly.Node(); !errors.Is(err, ErrSkipped) {
+		t.Fatalf("expected ErrSkipped for the whole node, got %v", err)
+	}
+
+	linksOnly, err := DecodeOptions{SkipData: true}.DecodePartial(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linksOnly.FieldData(); !errors.Is(err, ErrSkipped) || !linksOnly.DataSkipped() {
+		t.Fatalf("expected the Data skipped, got %v", err)
+	}
+	if links, err := linksOnly.FieldLinks(); err != nil || links.Length() != 2 {
+		t.Fatalf("unexpected Links, %v", err)
+	}
+
+	// a map assembler gets no entry at all for a skipped part
+	for _, opts := range []DecodeOptions{{SkipData: true}, {SkipLinks: true}} {
+		nb := basicnode.Prototype.Map.NewBuilder()
+		if err := opts.DecodeBytes(nb, src); err != nil {
+			t.Fatal(err)
+		}
+		n := nb.Build()
+		if n.Length() != 1 {
+			t.Fatalf("expected a single entry, got %d", n.Length())
+		}
+		skipped := "Data"
+		if opts.SkipLinks {
+			skipped = "Links"
+		}
+		if _, err := n.LookupByString(skipped); !errors.As(err, new(datamodel.ErrNotExists)) {
+			t.Fatalf("expected no %s, got %v", skipped, err)
+		}
+	}
+
+	// skipped links are still checked, unless asked not to
+	badHash := append(appendDataHeader(nil, nil), 0x12, 0x04, 0x0a, 0x02, 0x01, 0x02)
+	for _, tc := range []struct {
+		src  []byte
+		opts DecodeOptions
+	}{
+		{badHash, DecodeOptions{SkipLinks: true}},
+		{src, DecodeOptions{SkipLinks: true, LinkPolicy: &LinkPolicy{Versions: []uint64{0}}}},
+	} {
+		if _, err := tc.opts.DecodePartial(tc.src); err == nil {
+			t.Fatal("expected an error for a skipped link")
+		}
+		tc.opts.SkipValidation = true
+		if _, err := tc.opts.DecodePartial(tc.src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nor is a Visitor passed the skipped parts
+	rv := &recordingVisitor{}
+	if err := (DecodeOptions{SkipData: true}).Visit(src, rv); err != nil {
+		t.Fatal(err)
+	}
+	if calls := strings.Join(rv.calls, " "); strings.Contains(calls, "OnData") || !strings.Contains(calls, "OnHash") {
+		t.Fatalf("unexpected calls %s", calls)
+	}
+}
@@ -0,0 +1,56 @@
+package dagpb
+
+import (
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+// newPBNode assembles a PBNode directly from its parts, without going
+// through a NodeAssembler. The links slice is used as-is and must not be
+// modified afterwards.
+func newPBNode(links []_PBLink, data []byte, hasData bool) PBNode {
+	n := &_PBNode{Links: _PBLinks{x: links}}
+	if hasData {
+		n.Data = _Bytes__Maybe{m: schema.Maybe_Value, v: _Bytes{x: data}}
+	} else {
+		n.Data = _Bytes__Maybe{m: schema.Maybe_Absent}
+	}
+	return n
+}
+
+// newPBLink assembles a PBLink directly from its parts.
+func newPBLink(c cid.Cid, name string, hasName bool, tsize int64, hasTsize bool) _PBLink {
+	l := _PBLink{Hash: _Link{x: cidlink.Link{Cid: c}}}
+	if hasName {
+		l.Name = _String__Maybe{m: schema.Maybe_Value, v: _String{x: name}}
+	} else {
+		l.Name = _String__Maybe{m: schema.Maybe_Absent}
+	}
+	if hasTsize {
+		l.Tsize = _Int__Maybe{m: schema.Maybe_Value, v: _Int{x: tsize}}
+	} else {
+		l.Tsize = _Int__Maybe{m: schema.Maybe_Absent}
+	}
+	return l
+}
+
+// sortName is the Name used to order links, where an absent Name sorts as
+// an empty one.
+func (l *_PBLink) sortName() string {
+	if l.Name.m == schema.Maybe_Value {
+		return l.Name.v.x
+	}
+	return ""
+}
+
+// sortedInsertIndex returns the index at which a link with the given Name
+// should be inserted to keep links sorted, after any existing links with
+// the same Name so that insertion order is kept as a stable sort would.
+func sortedInsertIndex(links []_PBLink, name string) int {
+	return sort.Search(len(links), func(i int) bool {
+		return links[i].sortName() > name
+	})
+}
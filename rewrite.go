@@ -0,0 +1,80 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+// CIDMapping is the outcome of rewriting a DAG: the new root, and the new CID
+// of every block whose CID changed.
+type CIDMapping struct {
+	Root    cid.Cid
+	Mapping map[cid.Cid]cid.Cid
+	// Base is the multibase used for CIDv1 strings by Strings.
+	Base multibase.Encoding
+}
+
+// Strings returns the mapping with the CIDs as strings, CIDv1 in Base and
+// CIDv0 in base58btc, which is the only base CIDv0 has.
+func (m *CIDMapping) Strings() (map[string]string, error) {
+	str := func(c cid.Cid) (string, error) {
+		if c.Version() == 0 {
+			return c.String(), nil
+		}
+		return c.StringOfBase(m.Base)
+	}
+	strs := make(map[string]string, len(m.Mapping))
+	for from, to := range m.Mapping {
+		fromStr, err := str(from)
+		if err != nil {
+			return nil, err
+		}
+		toStr, err := str(to)
+		if err != nil {
+			return nil, err
+		}
+		strs[fromStr] = toStr
+	}
+	return strs, nil
+}
+
+// RewriteCIDVersion rewrites the DAG-PB blocks under root so that they, and
+// the links between them, use the given CID version, loading blocks from lsys
+// and storing the rewritten blocks there. Blocks are rewritten bottom-up,
+// and each only once however many times it is linked.
+//
+// Converting to CIDv1 changes every CIDv0. Converting to CIDv0 changes only
+// the CIDv1 DAG-PB blocks hashed with a 32 byte SHA2-256, as no other CID can
+// be expressed as CIDv0. Blocks of other codecs are neither loaded nor
+// changed. A block with a changed link is re-encoded with AppendEncode, and
+// any Tsize present on the links to it is adjusted by the change in size.
+// The mapping holds every block whose CID changed, with CIDv1 strings in
+// base for CIDMapping.Strings. See Transform for other changes to a DAG.
+func RewriteCIDVersion(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, version uint64, base multibase.Encoding) (*CIDMapping, error) {
+	if version > 1 {
+		return nil, fmt.Errorf("dagpb: invalid CID version %d", version)
+	}
+	if lsys.StorageWriteOpener == nil {
+		return nil, fmt.Errorf("dagpb: rewriting requires a LinkSystem with write storage")
+	}
+	result, err := Transform(ctx, lsys, root, TransformOptions{
+		Node: func(b *TransformBlock) error {
+			if version == 1 || (b.Prefix.MhType == multihash.SHA2_256 && b.Prefix.MhLength == 32) {
+				b.Prefix.Version = version
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := result.Store(ctx, lsys); err != nil {
+		return nil, err
+	}
+	return &CIDMapping{Root: result.Root, Mapping: result.Mapping, Base: base}, nil
+}
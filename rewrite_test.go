@@ -0,0 +1,109 @@
+package dagpb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+var pbLinkProtoV0 = cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 0, Codec: 0x70, MhType: multihash.SHA2_256, MhLength: 32}}
+
+func storeNodeV0(t *testing.T, lsys *ipld.LinkSystem, n pbNode) cid.Cid {
+	lnk, err := lsys.Store(ipld.LinkContext{}, pbLinkProtoV0, mkPBNode(t, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnk.(cidlink.Link).Cid
+}
+
+// mkV0DAG stores a DAG of CIDv0 DAG-PB blocks with correct Tsizes, and a
+// directory linked twice
+func mkV0DAG(t *testing.T, lsys *ipld.LinkSystem, size func(cid.Cid) uint64) (cid.Cid, int) {
+	leaf := storeRaw(t, lsys, []byte("leaf"))
+	chunk := storeNodeV0(t, lsys, pbNode{data: []byte{8, 2, 0x12, 1, 'x'}})
+	file := storeNodeV0(t, lsys, pbNode{data: []byte{8, 2}, links: []pbLink{
+		{hash: chunk, tsize: size(chunk), hasTsize: true},
+		{hash: leaf, tsize: size(leaf), hasTsize: true},
+	}})
+	fileSize := size(file) + size(chunk) + size(leaf)
+	shared := storeNodeV0(t, lsys, pbNode{data: []byte{8, 1}, links: []pbLink{namedLink("file", file, fileSize)}})
+	sharedSize := size(shared) + fileSize
+	root := storeNodeV0(t, lsys, pbNode{data: []byte{8, 1}, links: []pbLink{
+		namedLink("a", shared, sharedSize),
+		namedLink("b", shared, sharedSize),
+		namedLink("leaf", leaf, size(leaf)),
+	}})
+	return root, 4 // DAG-PB blocks
+}
+
+func TestRewriteCIDVersion(t *testing.T) {
+	ctx := context.Background()
+	lsys, store := mkLinkSystem()
+	size := func(c cid.Cid) uint64 { return uint64(len(store.Bag[string(c.KeyString())])) }
+	root, blocks := mkV0DAG(t, lsys, size)
+	if _, err := VerifyTsizes(ctx, lsys, root, func(m TsizeMismatch) error { t.Fatalf("bad test DAG: %+v", m); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := recordStores(lsys)
+	mapping, err := RewriteCIDVersion(ctx, lsys, root, 1, multibase.Base32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stored != blocks || len(mapping.Mapping) != blocks {
+		t.Fatalf("expected %d blocks rewritten once each, got %d stored and %d mapped", blocks, *stored, len(mapping.Mapping))
+	}
+	if !mapping.Mapping[root].Equals(mapping.Root) || mapping.Root.Version() != 1 {
+		t.Fatalf("unexpected root %s", mapping.Root)
+	}
+
+	// every link in the new DAG is CIDv1, and the Tsizes still add up
+	var mismatches []TsizeMismatch
+	if _, err := VerifyTsizes(ctx, lsys, mapping.Root, func(m TsizeMismatch) error {
+		mismatches = append(mismatches, m)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("unexpected Tsize mismatches: %+v", mismatches)
+	}
+	for _, to := range mapping.Mapping {
+		node := loadTestNode(t, store.Bag, to)
+		for ii := range node.Links.x {
+			if c := linkCid(&node.Links.x[ii]); c.Version() != 1 {
+				t.Fatalf("found a CIDv0 link %s in %s", c, to)
+			}
+		}
+	}
+
+	strs, err := mapping.Strings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := strs[root.String()]; !strings.HasPrefix(to, "bafy") {
+		t.Fatalf("expected a base32 CIDv1, got %q", to)
+	}
+
+	// converting back reproduces the original DAG exactly
+	back, err := RewriteCIDVersion(ctx, lsys, mapping.Root, 0, multibase.Base32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.Root.Equals(root) {
+		t.Fatalf("expected the original root %s, got %s", root, back.Root)
+	}
+
+	// nothing to do
+	*stored = 0
+	same, err := RewriteCIDVersion(ctx, lsys, root, 0, multibase.Base58BTC)
+	if err != nil || !same.Root.Equals(root) || len(same.Mapping) != 0 || *stored != 0 {
+		t.Fatalf("expected no changes, got %v %v", same, err)
+	}
+}
@@ -0,0 +1,149 @@
+package dagpb
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SalvageRegion is a range of input bytes that Salvage had to skip over,
+// along with the reason it couldn't be used.
+type SalvageRegion struct {
+	Offset int
+	Length int
+	Err    error
+}
+
+// SalvageReport describes what Salvage had to do to recover a PBNode.
+type SalvageReport struct {
+	// FailedAt is the offset at which strict decoding would first fail, or
+	// -1 where the input decodes cleanly.
+	FailedAt int
+	// Err is the error found at FailedAt, or nil.
+	Err error
+	// Skipped lists the regions of the input that contributed nothing to the
+	// recovered PBNode, in order.
+	Skipped []SalvageRegion
+}
+
+// Salvage is a best-effort alternative to DecodeBytes for recovering what
+// it can from damaged DAG-PB bytes. Where DecodeBytes gives up at the first
+// error, Salvage skips over anything it can't parse and keeps going, looking
+// for the next well-formed Links or Data field. Every link that parses on
+// its own is kept, as is the first Data field, so child CIDs can be
+// recovered from a corrupted intermediate node.
+//
+// The recovered PBNode is always returned, and the report says where strict
+// decoding would have failed and what was skipped. A PBNode recovered from
+// damaged input may be missing links, so should not be trusted as a
+// replacement for the original block. As with DecodeBytes, the Data of the
+// returned PBNode aliases src.
+func Salvage(src []byte) (PBNode, SalvageReport) {
+	s := salvager{src: src, report: SalvageReport{FailedAt: -1}}
+	links := make([]_PBLink, 0)
+	var data []byte
+	haveData := false
+	linksEnded := false
+
+	off := 0
+	for off < len(src) {
+		num, chunk, n, err := s.field(off)
+		if err != nil {
+			s.fail(off, err)
+			next := s.resync(off + 1)
+			s.skip(off, next-off, err)
+			off = next
+			continue
+		}
+
+		switch num {
+		case 1:
+			if haveData {
+				err := fmt.Errorf("protobuf: (PBNode) duplicate Data section")
+				s.fail(off, err)
+				s.skip(off, n, err)
+				break
+			}
+			data = chunk
+			haveData = true
+			linksEnded = len(links) > 0
+		case 2:
+			link, err := decodeLink(chunk)
+			if err != nil {
+				s.fail(off, err)
+				s.skip(off, n, err)
+				break
+			}
+			if linksEnded {
+				s.fail(off, fmt.Errorf("protobuf: (PBNode) duplicate Links section"))
+			}
+			links = append(links, link)
+		}
+		off += n
+	}
+
+	return newPBNode(links, data, haveData), s.report
+}
+
+type salvager struct {
+	src    []byte
+	report SalvageReport
+}
+
+func (s *salvager) fail(off int, err error) {
+	if s.report.Err == nil {
+		s.report.FailedAt = off
+		s.report.Err = err
+	}
+}
+
+func (s *salvager) skip(off int, length int, err error) {
+	s.report.Skipped = append(s.report.Skipped, SalvageRegion{Offset: off, Length: length, Err: err})
+}
+
+// field parses the PBNode field at off, returning its number, payload and
+// total length. Only the Data and Links fields are accepted.
+func (s *salvager) field(off int) (protowire.Number, []byte, int, error) {
+	remaining := s.src[off:]
+	fieldNum, wireType, n := protowire.ConsumeTag(remaining)
+	if n < 0 {
+		return 0, nil, 0, protowire.ParseError(n)
+	}
+	if wireType != 2 {
+		return 0, nil, 0, fmt.Errorf("protobuf: (PBNode) invalid wireType, expected 2, got %d", wireType)
+	}
+	if fieldNum != 1 && fieldNum != 2 {
+		return 0, nil, 0, fmt.Errorf("protobuf: (PBNode) invalid fieldNumber, expected 1 or 2, got %d", fieldNum)
+	}
+	chunk, m := protowire.ConsumeBytes(remaining[n:])
+	if m < 0 {
+		return 0, nil, 0, protowire.ParseError(m)
+	}
+	return fieldNum, chunk, n + m, nil
+}
+
+// resync finds the first offset at or after from where parsing can resume,
+// or the end of the input where there is none. A Links field is accepted if
+// its link parses, but since any bytes could be a Data field, those are only
+// accepted if they end the input or are followed by another valid field.
+func (s *salvager) resync(from int) int {
+	for off := from; off < len(s.src); off++ {
+		num, chunk, n, err := s.field(off)
+		if err != nil {
+			continue
+		}
+		if num == 2 {
+			if _, err := decodeLink(chunk); err == nil {
+				return off
+			}
+			continue
+		}
+		if off+n == len(s.src) {
+			return off
+		}
+		if _, _, _, err := s.field(off + n); err == nil {
+			return off
+		}
+	}
+	return len(s.src)
+}
@@ -0,0 +1,130 @@
+package dagpb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+func salvageHashes(node PBNode) []string {
+	var hashes []string
+	itr := node.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, link := itr.Next()
+		hashes = append(hashes, hex.EncodeToString(link.FieldHash().Link().(cidlink.Link).Bytes()))
+	}
+	return hashes
+}
+
+func TestSalvage(t *testing.T) {
+	const (
+		link1 = "120b0a09015500050001020304"                       // Hash 015500050001020304
+		link2 = "120b0a09015500050102030405"                       // Hash 015500050102030405
+		link3 = "12160a090155000502030405061209736f6d65206e616d65" // Hash 015500050203040506, Name "some name"
+		data  = "0a050001020304"
+	)
+	hash1, hash2, hash3 := "015500050001020304", "015500050102030405", "015500050203040506"
+
+	for _, tc := range []struct {
+		name     string
+		hex      string
+		hashes   []string
+		data     []byte
+		failedAt int
+		skipped  []SalvageRegion
+	}{
+		{
+			name:     "clean",
+			hex:      link1 + link2 + data,
+			hashes:   []string{hash1, hash2},
+			data:     dataSome,
+			failedAt: -1,
+		},
+		{
+			name:     "bad CID in a link",
+			hex:      link1 + "120b0a09015500060102030405" + link3 + data,
+			hashes:   []string{hash1, hash3},
+			data:     dataSome,
+			failedAt: 13,
+			skipped:  []SalvageRegion{{Offset: 13, Length: 13}},
+		},
+		{
+			name:     "garbage between links",
+			hex:      link1 + "ffffff" + link2 + link3,
+			hashes:   []string{hash1, hash2, hash3},
+			failedAt: 13,
+			skipped:  []SalvageRegion{{Offset: 13, Length: 3}},
+		},
+		{
+			name:     "truncated",
+			hex:      link1 + link2 + link3[:20],
+			hashes:   []string{hash1, hash2},
+			failedAt: 26,
+			skipped:  []SalvageRegion{{Offset: 26, Length: 10}},
+		},
+		{
+			name:     "duplicate Data",
+			hex:      link1 + data + "0a020102",
+			hashes:   []string{hash1},
+			data:     dataSome,
+			failedAt: 20,
+			skipped:  []SalvageRegion{{Offset: 20, Length: 4}},
+		},
+		{
+			name:     "corrupt length prefix before Data",
+			hex:      link1 + "12ff" + data,
+			hashes:   []string{hash1},
+			data:     dataSome,
+			failedAt: 13,
+			skipped:  []SalvageRegion{{Offset: 13, Length: 2}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			byts, _ := hex.DecodeString(tc.hex)
+			node, report := Salvage(byts)
+
+			hashes := salvageHashes(node)
+			if len(hashes) != len(tc.hashes) {
+				t.Fatalf("expected %d links, got %v", len(tc.hashes), hashes)
+			}
+			for ii := range hashes {
+				if hashes[ii] != tc.hashes[ii] {
+					t.Fatalf("expected links %v, got %v", tc.hashes, hashes)
+				}
+			}
+			if tc.data == nil {
+				if node.FieldData().Exists() {
+					t.Fatal("expected no Data")
+				}
+			} else if !node.FieldData().Exists() || !bytes.Equal(node.FieldData().Must().Bytes(), tc.data) {
+				t.Fatal("expected Data to be recovered")
+			}
+
+			if report.FailedAt != tc.failedAt {
+				t.Fatalf("expected failure at %d, got %d (%v)", tc.failedAt, report.FailedAt, report.Err)
+			}
+			if (report.Err == nil) != (tc.failedAt == -1) {
+				t.Fatalf("unexpected error: %v", report.Err)
+			}
+			if len(report.Skipped) != len(tc.skipped) {
+				t.Fatalf("expected %d skipped regions, got %v", len(tc.skipped), report.Skipped)
+			}
+			for ii, region := range report.Skipped {
+				if region.Offset != tc.skipped[ii].Offset || region.Length != tc.skipped[ii].Length || region.Err == nil {
+					t.Fatalf("unexpected skipped region: %+v", region)
+				}
+			}
+
+			// strict decoding agrees on whether the input is damaged
+			if err := DecodeBytes(Type.PBNode.NewBuilder(), byts); (err == nil) != (tc.failedAt == -1) {
+				t.Fatalf("DecodeBytes disagrees: %v", err)
+			}
+			// and what was recovered can be encoded
+			if _, err := AppendEncode(nil, node); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
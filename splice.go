@@ -0,0 +1,73 @@
+package dagpb
+
+import "fmt"
+
+// SpliceLink returns the encoded block src with link i replaced by link, as
+// would be encoded by decoding src, replacing the link and encoding it again
+// with AppendEncode. src is not changed.
+//
+// Where src is canonical, as produced by Encode, and link sorts in the same
+// place as the link it replaces, this is done without decoding the block into
+// a node, by splicing the newly encoded link into a copy of the bytes around
+// it. The other links are still checked, once for the LinkIndex.
+// Otherwise the block is decoded and encoded in full.
+func SpliceLink(src []byte, i int, link PBLink) ([]byte, error) {
+	x, err := NewLinkIndex(src)
+	if err != nil {
+		return nil, err
+	}
+	return x.Splice(i, link)
+}
+
+// Splice is like SpliceLink, for the indexed block.
+func (x *LinkIndex) Splice(i int, link PBLink) ([]byte, error) {
+	if i < 0 || i >= len(x.spans) {
+		return nil, fmt.Errorf("dagpb: link index %d out of range for %d links", i, len(x.spans))
+	}
+	if err := validatePBLink(link); err != nil {
+		return nil, err
+	}
+	if start, end, ok := x.spliceRange(i, link.sortName()); ok {
+		// the other links are kept as they are, so must be checked as a
+		// full decode would check them
+		if err := x.check(); err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(x.src)+64)
+		out = append(out, x.src[:start]...)
+		out = appendLink(out, toPBLink(link))
+		return append(out, x.src[end:]...), nil
+	}
+
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, x.src); err != nil {
+		return nil, err
+	}
+	node := nb.Build().(PBNode)
+	links := make([]_PBLink, len(node.Links.x))
+	copy(links, node.Links.x)
+	links[i] = *link
+	return AppendEncode(nil, withLinks(node, links))
+}
+
+// spliceRange returns the range of bytes taken by link i, tag and length
+// included, where the block is Canonical and a link with the given Name can
+// take its place without changing the order.
+func (x *LinkIndex) spliceRange(i int, name string) (int, int, bool) {
+	if !x.canonical {
+		return 0, 0, false
+	}
+	if i > 0 {
+		if prev, _ := x.Name(i - 1); name < prev {
+			return 0, 0, false
+		}
+	}
+	if i < len(x.spans)-1 {
+		if next, _ := x.Name(i + 1); name > next {
+			return 0, 0, false
+		}
+	}
+	s := x.spans[i]
+	start, _ := shortestHeader(x.src, x.Raw(i), 2) // checked by NewLinkIndex
+	return start, int(s.off + s.len), true
+}
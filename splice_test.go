@@ -0,0 +1,73 @@
+package dagpb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestSpliceLink(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	c2, err := IdentityCID(cid.Raw, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := make([]PBLink, 5000)
+	for ii := range links {
+		link, err := MakePBLink(fmt.Sprintf("%04d", ii), c, uint64(ii))
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[ii] = link
+	}
+	encodeWith := func(i int, link PBLink) []byte {
+		nb := NewNodeBuilder().SetData([]byte("data"))
+		for ii, l := range links {
+			if ii == i {
+				l = link
+			}
+			nb.AddPBLink(l)
+		}
+		return mustEncode(t, nb.mustBuild(t))
+	}
+	src := encodeWith(-1, nil)
+	orig := bytes.Clone(src)
+
+	for _, tc := range []struct {
+		name  string
+		i     int
+		link  string
+		tsize uint64
+	}{
+		{"bigger Tsize", 2500, "2500", 1 << 40},
+		{"smaller Tsize", 4999, "4999", 0},
+		{"same place", 0, "", 0},
+		{"moved", 10, "zzz", 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := MakePBLink(tc.link, c2, tc.tsize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spliced, err := SpliceLink(src, tc.i, link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(spliced, encodeWith(tc.i, link)) {
+				t.Fatal("splice differs from a full encode")
+			}
+			if !bytes.Equal(src, orig) {
+				t.Fatal("splice changed the source")
+			}
+		})
+	}
+
+	if _, err := SpliceLink(src, 5000, links[0]); err == nil {
+		t.Fatal("expected an error for an index out of range")
+	}
+	if _, err := SpliceLink(src, 0, &_PBLink{}); err == nil {
+		t.Fatal("expected an error for a link without a Hash")
+	}
+}
@@ -0,0 +1,414 @@
+package dagpb
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultMaxBufferedLinks is the number of links a StreamEncoder holds in
+// memory, where the links are not already sorted, unless
+// StreamEncoderOptions.MaxBufferedLinks says otherwise.
+const DefaultMaxBufferedLinks = 1 << 16
+
+// streamMergeFanIn is the number of runs merged into one when that many of
+// the same level build up, which bounds the number of open runs.
+const streamMergeFanIn = 64
+
+// StreamEncoderOptions can be used to customize a StreamEncoder.
+type StreamEncoderOptions struct {
+	// Sorted says the links will be added in the canonical order, sorted by
+	// Name, so each can be written as soon as it is added. Adding a link out
+	// of order is an error.
+	Sorted bool
+
+	// MaxBufferedLinks is the number of links held in memory at once where
+	// the links are not Sorted. Beyond that, they are sorted in runs through
+	// temporary files. Zero means DefaultMaxBufferedLinks.
+	MaxBufferedLinks int
+
+	// TempDir is the directory for the temporary files, os.TempDir where
+	// empty.
+	TempDir string
+
+	// LinkPolicy, if set, is checked against the Hash of each link as it is
+	// added.
+	LinkPolicy *LinkPolicy
+}
+
+// StreamEncoder encodes a DAG-PB block straight to an io.Writer, one link at
+// a time, for blocks with too many links to build as a node. The encoded form
+// is the same as that of Encode for a node with the same links and Data.
+//
+// Links that are not added in sorted order are sorted with a bounded amount
+// of memory: at most MaxBufferedLinks of them are held at once, and the rest
+// are kept in sorted runs in temporary files, merged as the block is
+// written. Links with the same Name keep the order they were added in.
+//
+//	enc := dagpb.NewStreamEncoder(w, dagpb.StreamEncoderOptions{})
+//	defer enc.Close()
+//	for _, entry := range entries {
+//		if err := enc.AddLink(entry.Name, entry.Cid, entry.Size); err != nil {
+//			return err
+//		}
+//	}
+//	enc.SetData(data)
+//	return enc.Finish()
+//
+// The first error is kept, and returned by every later call. Nothing more is
+// written after an error, but what was written before it is left as it is.
+type StreamEncoder struct {
+	w       *bufio.Writer
+	opts    StreamEncoderOptions
+	added   int
+	last    string
+	buf     []pbLink
+	runs    []*streamRun
+	scratch []byte
+	data    []byte
+	hasData bool
+	err     error
+}
+
+// NewStreamEncoder returns a StreamEncoder writing to w.
+func NewStreamEncoder(w io.Writer, opts StreamEncoderOptions) *StreamEncoder {
+	if opts.MaxBufferedLinks <= 0 {
+		opts.MaxBufferedLinks = DefaultMaxBufferedLinks
+	}
+	return &StreamEncoder{w: bufio.NewWriter(w), opts: opts}
+}
+
+// AddLink adds a link with all three of Hash, Name and Tsize set.
+func (e *StreamEncoder) AddLink(name string, c cid.Cid, tsize uint64) error {
+	if e.err != nil {
+		return e.err
+	}
+	if !c.Defined() {
+		return e.fail(fmt.Errorf("invalid DAG-PB form (link must have a Hash)"))
+	}
+	if tsize > math.MaxInt64 {
+		return e.fail(fmt.Errorf("Link has out of range Tsize value [%v]", tsize))
+	}
+	return e.add(pbLink{hash: c, name: name, hasName: true, tsize: tsize, hasTsize: true})
+}
+
+// AddPBLink adds an existing PBLink, which allows for links without a Name
+// or Tsize.
+func (e *StreamEncoder) AddPBLink(link PBLink) error {
+	if e.err != nil {
+		return e.err
+	}
+	if err := validatePBLink(link); err != nil {
+		return e.fail(err)
+	}
+	return e.add(toPBLink(link))
+}
+
+// AddLinks adds each of the links from an iterator, as by AddPBLink.
+func (e *StreamEncoder) AddLinks(links iter.Seq[PBLink]) error {
+	for link := range links {
+		if err := e.AddPBLink(link); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+// SetData sets the Data, which is written by Finish after the links. The
+// bytes are not copied. As with NodeBuilder.SetData, a nil Data is present
+// but empty; without a call to SetData the Data is absent.
+func (e *StreamEncoder) SetData(data []byte) {
+	if data == nil {
+		data = []byte{}
+	}
+	e.data = data
+	e.hasData = true
+}
+
+// Finish writes out the rest of the block, and removes any temporary files.
+// The StreamEncoder cannot be used after.
+func (e *StreamEncoder) Finish() error {
+	if e.err != nil {
+		return e.err
+	}
+	defer e.Close()
+	if len(e.runs) == 0 {
+		sort.Stable(pbLinkSlice(e.buf))
+		for _, link := range e.buf {
+			e.scratch = appendLink(e.scratch[:0], link)
+			if _, err := e.w.Write(e.scratch); err != nil {
+				return e.fail(err)
+			}
+		}
+	} else {
+		if len(e.buf) > 0 {
+			if err := e.spill(); err != nil {
+				return e.fail(err)
+			}
+		}
+		err := mergeRuns(e.runs, func(_, rec []byte) error {
+			_, err := e.w.Write(rec)
+			return err
+		})
+		if err != nil {
+			return e.fail(err)
+		}
+	}
+	if e.hasData {
+		if _, err := e.w.Write(appendDataHeader(e.scratch[:0], e.data)); err != nil {
+			return e.fail(err)
+		}
+		if _, err := e.w.Write(e.data); err != nil {
+			return e.fail(err)
+		}
+	}
+	if err := e.w.Flush(); err != nil {
+		return e.fail(err)
+	}
+	e.err = fmt.Errorf("dagpb: StreamEncoder already finished")
+	return nil
+}
+
+// Close removes any temporary files, without finishing the block. It is safe
+// to call more than once, and after Finish.
+func (e *StreamEncoder) Close() error {
+	var errs []error
+	for _, r := range e.runs {
+		errs = append(errs, r.remove())
+	}
+	e.runs = nil
+	e.buf = nil
+	if e.err == nil {
+		e.err = fmt.Errorf("dagpb: StreamEncoder closed")
+	}
+	return errors.Join(errs...)
+}
+
+func (e *StreamEncoder) fail(err error) error {
+	e.err = err
+	return err
+}
+
+func (e *StreamEncoder) add(link pbLink) error {
+	if err := e.opts.LinkPolicy.check(link.hash, e.added); err != nil {
+		return e.fail(err)
+	}
+	e.added++
+	if e.opts.Sorted {
+		if link.name < e.last {
+			return e.fail(fmt.Errorf("dagpb: link %q added after %q, out of sorted order", link.name, e.last))
+		}
+		e.last = link.name
+		e.scratch = appendLink(e.scratch[:0], link)
+		if _, err := e.w.Write(e.scratch); err != nil {
+			return e.fail(err)
+		}
+		return nil
+	}
+	e.buf = append(e.buf, link)
+	if len(e.buf) >= e.opts.MaxBufferedLinks {
+		if err := e.spill(); err != nil {
+			return e.fail(err)
+		}
+	}
+	return nil
+}
+
+// spill writes the buffered links to a new run, merging runs where enough of
+// the same level have built up.
+func (e *StreamEncoder) spill() error {
+	sort.Stable(pbLinkSlice(e.buf))
+	run, err := e.newRun(0)
+	if err != nil {
+		return err
+	}
+	e.runs = append(e.runs, run)
+	for _, link := range e.buf {
+		e.scratch = appendLink(e.scratch[:0], link)
+		if err := run.write(link.name, e.scratch); err != nil {
+			return err
+		}
+	}
+	if err := run.w.Flush(); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+
+	// Only ever merging the most recent runs keeps the runs in the order
+	// the links were added, which the merge relies on to be stable.
+	for len(e.runs) >= streamMergeFanIn {
+		tail := e.runs[len(e.runs)-streamMergeFanIn:]
+		level := tail[0].level
+		for _, r := range tail {
+			if r.level != level {
+				return nil
+			}
+		}
+		merged, err := e.newRun(level + 1)
+		if err != nil {
+			return err
+		}
+		err = mergeRuns(tail, func(name, rec []byte) error {
+			return merged.write(string(name), rec)
+		})
+		if err != nil {
+			merged.remove()
+			return err
+		}
+		if err := merged.w.Flush(); err != nil {
+			merged.remove()
+			return err
+		}
+		for _, r := range tail {
+			if err := r.remove(); err != nil {
+				merged.remove()
+				return err
+			}
+		}
+		e.runs = append(e.runs[:len(e.runs)-streamMergeFanIn], merged)
+	}
+	return nil
+}
+
+func (e *StreamEncoder) newRun(level int) (*streamRun, error) {
+	f, err := os.CreateTemp(e.opts.TempDir, "dagpb-links-*")
+	if err != nil {
+		return nil, err
+	}
+	return &streamRun{f: f, w: bufio.NewWriter(f), level: level}, nil
+}
+
+// streamRun is a temporary file of sorted links, each the Name as a length
+// prefixed string followed by the encoded link, likewise prefixed. Runs of
+// level 0 are spilled from memory; a run of level n+1 is merged from
+// streamMergeFanIn runs of level n.
+type streamRun struct {
+	f     *os.File
+	w     *bufio.Writer
+	level int
+}
+
+func (r *streamRun) write(name string, rec []byte) error {
+	var lb [binary.MaxVarintLen64]byte
+	if _, err := r.w.Write(binary.AppendUvarint(lb[:0], uint64(len(name)))); err != nil {
+		return err
+	}
+	if _, err := r.w.WriteString(name); err != nil {
+		return err
+	}
+	if _, err := r.w.Write(binary.AppendUvarint(lb[:0], uint64(len(rec)))); err != nil {
+		return err
+	}
+	_, err := r.w.Write(rec)
+	return err
+}
+
+func (r *streamRun) remove() error {
+	return errors.Join(r.f.Close(), os.Remove(r.f.Name()))
+}
+
+// runCursor is the next link in a run being merged.
+type runCursor struct {
+	r     *bufio.Reader
+	order int
+	name  []byte
+	rec   []byte
+}
+
+// next reads the next link, returning false at the end of the run.
+func (c *runCursor) next() (bool, error) {
+	n, err := binary.ReadUvarint(c.r)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if c.name, err = readRunBytes(c.r, c.name, n); err != nil {
+		return false, err
+	}
+	if n, err = binary.ReadUvarint(c.r); err != nil {
+		return false, io.ErrUnexpectedEOF
+	}
+	if c.rec, err = readRunBytes(c.r, c.rec, n); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func readRunBytes(r io.Reader, buf []byte, n uint64) ([]byte, error) {
+	if uint64(cap(buf)) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// runHeap orders cursors by Name, then by the order of their runs.
+type runHeap []*runCursor
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(a, b int) bool {
+	if c := string(h[a].name); c != string(h[b].name) {
+		return c < string(h[b].name)
+	}
+	return h[a].order < h[b].order
+}
+func (h runHeap) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runCursor)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// mergeRuns merges the sorted runs, which must be in the order their links
+// were added, passing each link to fn in sorted order.
+func mergeRuns(runs []*streamRun, fn func(name, rec []byte) error) error {
+	h := make(runHeap, 0, len(runs))
+	for ii, run := range runs {
+		if _, err := run.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		c := &runCursor{r: bufio.NewReader(run.f), order: ii}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		c := h[0]
+		if err := fn(c.name, c.rec); err != nil {
+			return err
+		}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package dagpb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestStreamEncoder(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	rnd := rand.New(rand.NewSource(1))
+	links := make([]PBLink, 1000)
+	for ii := range links {
+		// few distinct names, so plenty of ties to keep in order
+		name := fmt.Sprintf("%02d", rnd.Intn(50))
+		link := newPBLink(c, name, ii%7 != 0, int64(ii), ii%5 != 0)
+		links[ii] = &link
+	}
+	nb := NewNodeBuilder().SetData([]byte("data"))
+	for _, link := range links {
+		nb.AddPBLink(link)
+	}
+	expected := mustEncode(t, nb.mustBuild(t))
+
+	for _, max := range []int{0, 1, 4, 100} {
+		t.Run(fmt.Sprintf("max %d", max), func(t *testing.T) {
+			dir := t.TempDir()
+			var buf bytes.Buffer
+			enc := NewStreamEncoder(&buf, StreamEncoderOptions{MaxBufferedLinks: max, TempDir: dir})
+			if err := enc.AddLinks(slices.Values(links)); err != nil {
+				t.Fatal(err)
+			}
+			enc.SetData([]byte("data"))
+			if err := enc.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) {
+				t.Fatal("streamed encoding differs from Encode")
+			}
+			if files, _ := os.ReadDir(dir); len(files) != 0 {
+				t.Fatalf("expected the temporary files removed, found %d", len(files))
+			}
+			if err := enc.AddLink("late", c, 1); err == nil {
+				t.Fatal("expected an error adding after Finish")
+			}
+		})
+	}
+
+	// sorted links are written as they come, and must stay sorted
+	var buf bytes.Buffer
+	enc := NewStreamEncoder(&buf, StreamEncoderOptions{Sorted: true, MaxBufferedLinks: 1, TempDir: t.TempDir()})
+	for _, name := range []string{"a", "b", "b"} {
+		if err := enc.AddLink(name, c, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.AddLink("a", c, 1); err == nil || enc.Finish() != err {
+		t.Fatalf("expected a sticky out of order error, got %v", err)
+	}
+
+	// nothing at all
+	buf.Reset()
+	enc = NewStreamEncoder(&buf, StreamEncoderOptions{})
+	if err := enc.Finish(); err != nil || buf.Len() != 0 {
+		t.Fatalf("expected an empty block, got %x %v", buf.Bytes(), err)
+	}
+
+	// closing early removes the runs
+	dir := t.TempDir()
+	enc = NewStreamEncoder(&buf, StreamEncoderOptions{MaxBufferedLinks: 1, TempDir: dir})
+	for ii := 0; ii < 3; ii++ {
+		if err := enc.AddLink("x", c, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Fatalf("expected 3 runs, found %d", len(files))
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected the temporary files removed, found %d", len(files))
+	}
+
+	policy := &LinkPolicy{Versions: []uint64{0}}
+	enc = NewStreamEncoder(&buf, StreamEncoderOptions{LinkPolicy: policy})
+	var lpe *LinkPolicyError
+	if err := enc.AddLink("x", c, 1); !errors.As(err, &lpe) {
+		t.Fatalf("expected a LinkPolicyError, got %v", err)
+	}
+}
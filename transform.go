@@ -0,0 +1,244 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+// TransformOptions holds the callbacks for Transform. Either may be nil.
+type TransformOptions struct {
+	// Replace is called once for the root and each distinct CID reached by a
+	// link, before it is visited. Returning a defined CID replaces the whole
+	// subtree with that CID, which is not visited. Returning cid.Undef visits
+	// the block as usual.
+	Replace func(c cid.Cid) (cid.Cid, error)
+
+	// Node is called once for each block visited, after the links within it
+	// have been transformed, and may change the block in place.
+	Node func(b *TransformBlock) error
+
+	// Leaves visits blocks of codecs other than DAG-PB, which are otherwise
+	// left as they are without being loaded.
+	Leaves bool
+}
+
+// TransformBlock is a block being transformed, as passed to the Node
+// callback of TransformOptions. The block is stored as Node, encoded
+// canonically, unless Node is nil, in which case Raw is stored as-is. Either
+// way, the new CID is made with Prefix, whose codec must match.
+type TransformBlock struct {
+	// Cid is the original CID of the block.
+	Cid cid.Cid
+	// Prefix is the prefix of the new CID, initially that of Cid.
+	Prefix cid.Prefix
+	// Node is a DAG-PB block, with the links already transformed.
+	Node PBNode
+	// Raw is a block of another codec, where TransformOptions.Leaves is set.
+	Raw []byte
+}
+
+// TransformResult is the outcome of Transform.
+type TransformResult struct {
+	// Root is the root of the transformed DAG.
+	Root cid.Cid
+	// Mapping holds the new CID of each block whose CID changed, including
+	// replaced subtrees.
+	Mapping map[cid.Cid]cid.Cid
+
+	blocks []transformedBlock
+}
+
+type transformedBlock struct {
+	c   cid.Cid
+	enc []byte
+}
+
+// Blocks returns an iterator over the new blocks made by Transform, with
+// their CIDs, in the order they were made: every block comes after the
+// blocks it links to.
+func (r *TransformResult) Blocks() iter.Seq2[cid.Cid, []byte] {
+	return func(yield func(cid.Cid, []byte) bool) {
+		for _, b := range r.blocks {
+			if !yield(b.c, b.enc) {
+				return
+			}
+		}
+	}
+}
+
+// Store writes the new blocks made by Transform to lsys.
+func (r *TransformResult) Store(ctx context.Context, lsys *ipld.LinkSystem) error {
+	if lsys.StorageWriteOpener == nil {
+		return fmt.Errorf("dagpb: storing requires a LinkSystem with write storage")
+	}
+	for c, enc := range r.Blocks() {
+		if _, err := storeBlock(ctx, lsys, c.Prefix(), enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transform rebuilds the DAG under root, loading blocks from lsys, with the
+// changes made by the callbacks in opts: replacing subtrees, changing the
+// content of blocks or the CID prefix they are stored with (for a different
+// CID version or hash function), or turning DAG-PB blocks into blocks of
+// another codec.
+//
+// The DAG is walked depth-first and rebuilt bottom-up. Each distinct block
+// is visited once, however many times it is linked. A DAG-PB block is
+// re-encoded, canonically as by AppendEncode, where any of its links change
+// or the Node callback changes it, and so every ancestor of a change is
+// rebuilt. Links are updated in place, keeping their Name, and any Tsize
+// present is adjusted by the change in size below it; Tsizes of links to
+// replaced subtrees are left as they are, as the size of the replacement is
+// not known.
+//
+// The new blocks are held in the result, not stored; see
+// TransformResult.Blocks and TransformResult.Store.
+func Transform(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, opts TransformOptions) (*TransformResult, error) {
+	t := &transformer{ctx: ctx, lsys: lsys, opts: opts, seen: make(map[cid.Cid]transformed)}
+	r, err := t.transform(root)
+	if err != nil {
+		return nil, err
+	}
+	result := &TransformResult{Root: r.c, Mapping: make(map[cid.Cid]cid.Cid), blocks: t.blocks}
+	for from, to := range t.seen {
+		if !from.Equals(to.c) {
+			result.Mapping[from] = to.c
+		}
+	}
+	return result, nil
+}
+
+type transformer struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	opts   TransformOptions
+	seen   map[cid.Cid]transformed
+	blocks []transformedBlock
+}
+
+// transformed is a block's new CID, and the change in the cumulative size of
+// the blocks under it.
+type transformed struct {
+	c     cid.Cid
+	delta int64
+}
+
+func (t *transformer) transform(c cid.Cid) (transformed, error) {
+	if tr, ok := t.seen[c]; ok {
+		return tr, nil
+	}
+	if err := t.ctx.Err(); err != nil {
+		return transformed{}, err
+	}
+	if t.opts.Replace != nil {
+		replacement, err := t.opts.Replace(c)
+		if err != nil {
+			return transformed{}, err
+		}
+		if replacement.Defined() {
+			tr := transformed{c: replacement}
+			t.seen[c] = tr
+			return tr, nil
+		}
+	}
+	isPB := c.Prefix().Codec == cid.DagProtobuf
+	if !isPB && !t.opts.Leaves {
+		return transformed{c: c}, nil
+	}
+	block, err := t.lsys.LoadRaw(ipld.LinkContext{Ctx: t.ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		return transformed{}, err
+	}
+
+	b := &TransformBlock{Cid: c, Prefix: c.Prefix()}
+	var decoded PBNode
+	var delta int64
+	if isPB {
+		nb := Type.PBNode.NewBuilder()
+		if err := DecodeBytes(nb, block); err != nil {
+			return transformed{}, err
+		}
+		decoded = nb.Build().(PBNode)
+		if b.Node, delta, err = t.transformLinks(decoded); err != nil {
+			return transformed{}, err
+		}
+	} else {
+		b.Raw = block
+	}
+	if t.opts.Node != nil {
+		if err := t.opts.Node(b); err != nil {
+			return transformed{}, err
+		}
+	}
+
+	enc := block
+	switch {
+	case b.Node != nil:
+		if b.Prefix.Codec != cid.DagProtobuf {
+			return transformed{}, fmt.Errorf("dagpb: transformed DAG-PB block %s must have a DAG-PB prefix, not codec 0x%x", c, b.Prefix.Codec)
+		}
+		if b.Node != decoded {
+			if enc, err = AppendEncode(nil, b.Node); err != nil {
+				return transformed{}, err
+			}
+		}
+	case b.Raw != nil:
+		enc = b.Raw
+	default:
+		return transformed{}, fmt.Errorf("dagpb: transformed block %s has neither Node nor Raw", c)
+	}
+	delta += int64(len(enc)) - int64(len(block))
+
+	tr := transformed{c: c, delta: delta}
+	if b.Prefix != c.Prefix() || string(enc) != string(block) {
+		if tr.c, err = b.Prefix.Sum(enc); err != nil {
+			return transformed{}, err
+		}
+		if !tr.c.Equals(c) {
+			t.blocks = append(t.blocks, transformedBlock{c: tr.c, enc: enc})
+		}
+	}
+	t.seen[c] = tr
+	return tr, nil
+}
+
+// transformLinks transforms the targets of the links of node, returning a
+// copy of node with the links updated, or node itself where none changed,
+// along with the change in the cumulative size of the blocks below it.
+func (t *transformer) transformLinks(node PBNode) (PBNode, int64, error) {
+	var links []_PBLink // copied on the first change
+	var delta int64
+	for ii := range node.Links.x {
+		l := &node.Links.x[ii]
+		child := linkCid(l)
+		tr, err := t.transform(child)
+		if err != nil {
+			return nil, 0, err
+		}
+		delta += tr.delta
+		if tr.c.Equals(child) && tr.delta == 0 {
+			continue
+		}
+		if links == nil {
+			links = make([]_PBLink, len(node.Links.x))
+			copy(links, node.Links.x)
+		}
+		links[ii].Hash = _Link{cidlink.Link{Cid: tr.c}}
+		if l.Tsize.Exists() {
+			links[ii].Tsize = _Int__Maybe{m: schema.Maybe_Value, v: _Int{x: l.Tsize.v.x + tr.delta}}
+		}
+	}
+	if links == nil {
+		return node, delta, nil
+	}
+	return withLinks(node, links), delta, nil
+}
@@ -0,0 +1,124 @@
+package dagpb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestTransform(t *testing.T) {
+	ctx := context.Background()
+	lsys, store := mkLinkSystem()
+	size := func(c cid.Cid) uint64 { return uint64(len(store.Bag[string(c.KeyString())])) }
+	root, _ := mkV0DAG(t, lsys, size)
+
+	// swap every hash for blake3, leaves included
+	blake3 := func(b *TransformBlock) error {
+		b.Prefix.Version = 1
+		b.Prefix.MhType = multihash.BLAKE3
+		b.Prefix.MhLength = -1
+		return nil
+	}
+	result, err := Transform(ctx, lsys, root, TransformOptions{Node: blake3, Leaves: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Mapping) != 5 || !result.Mapping[root].Equals(result.Root) {
+		t.Fatalf("expected 5 blocks mapped including the root, got %d", len(result.Mapping))
+	}
+	made := make(map[cid.Cid]bool)
+	for c, enc := range result.Blocks() {
+		if c.Prefix().MhType != multihash.BLAKE3 {
+			t.Fatalf("unexpected hash function in %s", c)
+		}
+		if err := verifyHash(c, enc); err != nil {
+			t.Fatal(err)
+		}
+		if c.Prefix().Codec == cid.DagProtobuf {
+			node := loadBlock(t, enc)
+			for ii := range node.Links.x {
+				if child := linkCid(&node.Links.x[ii]); !made[child] {
+					t.Fatalf("%s came before its child %s", c, child)
+				}
+			}
+		}
+		made[c] = true
+	}
+	if len(made) != 5 {
+		t.Fatalf("expected each block made once, got %d", len(made))
+	}
+	if err := result.Store(ctx, lsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTsizes(ctx, lsys, result.Root, func(m TsizeMismatch) error { t.Fatalf("unexpected mismatch %+v", m); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the shared directory, and turn the file's dag-pb chunk into a raw leaf
+	original := loadTestNode(t, store.Bag, root)
+	shared := linkCid(&original.Links.x[0])
+	replacement := storeNode(t, lsys, pbNode{data: []byte{8, 1}})
+	result, err = Transform(ctx, lsys, root, TransformOptions{
+		Replace: func(c cid.Cid) (cid.Cid, error) {
+			if c.Equals(shared) {
+				return replacement, nil
+			}
+			return cid.Undef, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := loadBlock(t, lastBlock(t, result))
+	if !linkCid(&node.Links.x[0]).Equals(replacement) || !linkCid(&node.Links.x[1]).Equals(replacement) {
+		t.Fatal("expected both links to the shared directory replaced")
+	}
+	if len(result.Mapping) != 2 {
+		t.Fatalf("expected the replaced directory and the root mapped, got %d", len(result.Mapping))
+	}
+
+	file := linkCid(&loadTestNode(t, store.Bag, shared).Links.x[0])
+	chunk := linkCid(&loadTestNode(t, store.Bag, file).Links.x[0])
+	result, err = Transform(ctx, lsys, root, TransformOptions{
+		Node: func(b *TransformBlock) error {
+			if b.Cid.Equals(chunk) {
+				b.Raw = b.Node.FieldData().Must().Bytes()[4:]
+				b.Node = nil
+				b.Prefix = cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := result.Mapping[chunk]; to.Prefix().Codec != cid.Raw {
+		t.Fatalf("expected a raw leaf, got %s", to)
+	}
+	if len(result.Mapping) != 4 {
+		t.Fatalf("expected the chunk and its 3 ancestors mapped, got %d", len(result.Mapping))
+	}
+
+	// nothing to do
+	result, err = Transform(ctx, lsys, root, TransformOptions{})
+	if err != nil || !result.Root.Equals(root) || len(result.Mapping) != 0 || len(result.blocks) != 0 {
+		t.Fatalf("expected no changes, got %v %v", result, err)
+	}
+}
+
+func loadBlock(t *testing.T, enc []byte) PBNode {
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, enc); err != nil {
+		t.Fatal(err)
+	}
+	return nb.Build().(PBNode)
+}
+
+func lastBlock(t *testing.T, result *TransformResult) []byte {
+	if len(result.blocks) == 0 {
+		t.Fatal("expected new blocks")
+	}
+	return result.blocks[len(result.blocks)-1].enc
+}
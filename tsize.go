@@ -0,0 +1,160 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+// TsizeMismatch is a link whose Tsize is absent or does not match the
+// cumulative size of the blocks under it, as found by VerifyTsizes or
+// RepairTsizes.
+type TsizeMismatch struct {
+	// Path is the link Names from the root to the link, joined with "/". A
+	// link without a Name, or with an empty Name, is given its index.
+	Path string
+	// Parent is the block holding the link, and Index its position there.
+	Parent cid.Cid
+	Index  int
+	// Recorded is the Tsize of the link, where HasRecorded is set.
+	Recorded    uint64
+	HasRecorded bool
+	// Actual is the size of the target block plus the Actual sizes of the
+	// links below it.
+	Actual uint64
+}
+
+// VerifyTsizes walks the DAG under root, loading every block from lsys, and
+// calls fn (which may be nil) with each link whose Tsize is absent or wrong.
+// An error returned by fn stops the walk and is returned. The cumulative size of the DAG,
+// which is the correct Tsize for a link to root, is returned.
+//
+// The correct Tsize of a link is the size of the block it links to, plus
+// the correct Tsizes of that block's own links. Blocks of codecs other than
+// DAG-PB are counted by size alone. Each block is only examined once, so
+// mismatches below a block that is reachable by more than one path are
+// reported on the first path found.
+func VerifyTsizes(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, fn func(TsizeMismatch) error) (uint64, error) {
+	t := &tsizer{ctx: ctx, lsys: lsys, fn: fn, seen: make(map[cid.Cid]tsizeResult)}
+	r, err := t.walk("", root)
+	if err != nil {
+		return 0, err
+	}
+	return r.actual, nil
+}
+
+// RepairTsizes walks the DAG under root as VerifyTsizes does, calling fn
+// (which may be nil) with each mismatch, and rewrites the DAG-PB blocks
+// whose links need a new Tsize or point at a rewritten block. Blocks are
+// rewritten bottom-up, encoded canonically as by AppendEncode, and stored
+// to lsys under the CID prefix of the block they replace. The root of the
+// repaired DAG is returned, which is root itself if nothing needed
+// repairing, along with its cumulative size.
+func RepairTsizes(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, fn func(TsizeMismatch) error) (cid.Cid, uint64, error) {
+	if lsys.StorageWriteOpener == nil {
+		return cid.Undef, 0, fmt.Errorf("dagpb: repair requires a LinkSystem with write storage")
+	}
+	t := &tsizer{ctx: ctx, lsys: lsys, fn: fn, repair: true, seen: make(map[cid.Cid]tsizeResult)}
+	r, err := t.walk("", root)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return r.c, r.repaired, nil
+}
+
+type tsizer struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	fn     func(TsizeMismatch) error
+	repair bool
+	seen   map[cid.Cid]tsizeResult
+}
+
+// tsizeResult is the outcome of walking a block: its cumulative size, and
+// where repairing, its replacement and the replacement's cumulative size.
+type tsizeResult struct {
+	actual   uint64
+	c        cid.Cid
+	repaired uint64
+}
+
+func (t *tsizer) walk(path string, c cid.Cid) (tsizeResult, error) {
+	if r, ok := t.seen[c]; ok {
+		return r, nil
+	}
+	if err := t.ctx.Err(); err != nil {
+		return tsizeResult{}, err
+	}
+	block, err := t.lsys.LoadRaw(ipld.LinkContext{Ctx: t.ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		return tsizeResult{}, err
+	}
+	r := tsizeResult{actual: uint64(len(block)), c: c, repaired: uint64(len(block))}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		t.seen[c] = r
+		return r, nil
+	}
+	nb := Type.PBNode.NewBuilder()
+	if err := DecodeBytes(nb, block); err != nil {
+		return tsizeResult{}, err
+	}
+	node := nb.Build().(PBNode)
+
+	var links []_PBLink // copied on the first change
+	var repairedLinks uint64
+	for ii := range node.Links.x {
+		l := &node.Links.x[ii]
+		segment := l.sortName()
+		if segment == "" {
+			segment = strconv.Itoa(ii)
+		}
+		childPath := segment
+		if path != "" {
+			childPath = path + "/" + segment
+		}
+		child := linkCid(l)
+		cr, err := t.walk(childPath, child)
+		if err != nil {
+			return tsizeResult{}, err
+		}
+		r.actual += cr.actual
+		repairedLinks += cr.repaired
+
+		if t.fn != nil && (!l.Tsize.Exists() || uint64(l.Tsize.v.x) != cr.actual) {
+			m := TsizeMismatch{Path: childPath, Parent: c, Index: ii, Actual: cr.actual}
+			if l.Tsize.Exists() {
+				m.Recorded, m.HasRecorded = uint64(l.Tsize.v.x), true
+			}
+			if err := t.fn(m); err != nil {
+				return tsizeResult{}, err
+			}
+		}
+		if t.repair && (!cr.c.Equals(child) || !l.Tsize.Exists() || uint64(l.Tsize.v.x) != cr.repaired) {
+			if links == nil {
+				links = make([]_PBLink, len(node.Links.x))
+				copy(links, node.Links.x)
+			}
+			links[ii].Hash = _Link{cidlink.Link{Cid: cr.c}}
+			links[ii].Tsize = _Int__Maybe{m: schema.Maybe_Value, v: _Int{x: int64(cr.repaired)}}
+		}
+	}
+
+	r.repaired += repairedLinks
+	if links != nil {
+		enc, err := AppendEncode(nil, newPBNode(links, maybeData(node), node.Data.Exists()))
+		if err != nil {
+			return tsizeResult{}, err
+		}
+		if r.c, err = storeBlock(t.ctx, t.lsys, c.Prefix(), enc); err != nil {
+			return tsizeResult{}, err
+		}
+		r.repaired = uint64(len(enc)) + repairedLinks
+	}
+	t.seen[c] = r
+	return r, nil
+}
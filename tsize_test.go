@@ -0,0 +1,82 @@
+package dagpb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestTsizes(t *testing.T) {
+	ctx := context.Background()
+	lsys, store := mkLinkSystem()
+	size := func(c cid.Cid) uint64 { return uint64(len(store.Bag[string(c.KeyString())])) }
+
+	// a file of two chunks with unnamed links, one Tsize absent
+	chunk1, chunk2 := storeRaw(t, lsys, []byte("chunk one")), storeRaw(t, lsys, []byte("chunk 2"))
+	file := storeNode(t, lsys, pbNode{data: []byte{8, 2}, links: []pbLink{
+		{hash: chunk1, tsize: size(chunk1), hasTsize: true},
+		{hash: chunk2},
+	}})
+	fileSize := size(file) + size(chunk1) + size(chunk2)
+	// a directory with a correct link and a wrong one
+	shared := storeRaw(t, lsys, []byte("shared"))
+	sub := storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: []pbLink{
+		namedLink("file", file, fileSize+1),
+		namedLink("ok", shared, size(shared)),
+	}})
+	root := storeNode(t, lsys, pbNode{data: []byte{8, 1}, links: []pbLink{
+		namedLink("again", shared, size(shared)),
+		namedLink("sub", sub, 0),
+	}})
+
+	var mismatches []string
+	report := func(m TsizeMismatch) error {
+		recorded := "absent"
+		if m.HasRecorded {
+			recorded = fmt.Sprint(m.Recorded)
+		}
+		mismatches = append(mismatches, fmt.Sprintf("%s:%s!=%d", m.Path, recorded, m.Actual))
+		return nil
+	}
+	total, err := VerifyTsizes(ctx, lsys, root, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subSize := size(sub) + fileSize + size(shared)
+	expected := []string{
+		fmt.Sprintf("sub/file/1:absent!=%d", size(chunk2)),
+		fmt.Sprintf("sub/file:%d!=%d", fileSize+1, fileSize),
+		fmt.Sprintf("sub:0!=%d", subSize),
+	}
+	if strings.Join(mismatches, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected mismatches:\n%v\nexpected:\n%v", mismatches, expected)
+	}
+	if total != size(root)+size(shared)+subSize {
+		t.Fatalf("unexpected total %d", total)
+	}
+
+	mismatches = nil
+	repaired, repairedTotal, err := RepairTsizes(ctx, lsys, root, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(mismatches, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected mismatches when repairing: %v", mismatches)
+	}
+	if repaired.Equals(root) || repaired.Prefix() != root.Prefix() {
+		t.Fatalf("expected a new root with the same prefix, got %s", repaired)
+	}
+
+	// the repaired DAG is consistent, and repairing it again changes nothing
+	mismatches = nil
+	if total, err := VerifyTsizes(ctx, lsys, repaired, report); err != nil || total != repairedTotal || len(mismatches) != 0 {
+		t.Fatalf("expected a consistent DAG of %d, got %d, %v, %v", repairedTotal, total, mismatches, err)
+	}
+	again, _, err := RepairTsizes(ctx, lsys, repaired, nil)
+	if err != nil || !again.Equals(repaired) {
+		t.Fatalf("expected no further repairs, got %s, %v", again, err)
+	}
+}
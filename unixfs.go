@@ -0,0 +1,82 @@
+package dagpb
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A minimal reading of the UnixFS Data message carried in the Data of a
+// PBNode, enough to tell directories, files and HAMT shards apart. Fields
+// not listed here are skipped.
+//
+//	message Data {
+//		required DataType Type = 1;
+//		optional bytes Data = 2;
+//		optional uint64 filesize = 3;
+//		repeated uint64 blocksizes = 4;
+//		optional uint64 hashType = 5;
+//		optional uint64 fanout = 6;
+//		...
+//	}
+
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsMetadata  = 3
+	unixfsSymlink   = 4
+	unixfsHAMTShard = 5
+)
+
+type unixfsData struct {
+	typ      uint64
+	hashType uint64
+	fanout   uint64
+}
+
+func parseUnixFSData(src []byte) (unixfsData, error) {
+	var ud unixfsData
+	var hasType bool
+	for len(src) > 0 {
+		fieldNum, wireType, n := protowire.ConsumeTag(src)
+		if n < 0 {
+			return ud, protowire.ParseError(n)
+		}
+		src = src[n:]
+		if wireType == protowire.VarintType && (fieldNum == 1 || fieldNum == 5 || fieldNum == 6) {
+			v, n := protowire.ConsumeVarint(src)
+			if n < 0 {
+				return ud, protowire.ParseError(n)
+			}
+			src = src[n:]
+			switch fieldNum {
+			case 1:
+				ud.typ, hasType = v, true
+			case 5:
+				ud.hashType = v
+			case 6:
+				ud.fanout = v
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(fieldNum, wireType, src)
+		if n < 0 {
+			return ud, protowire.ParseError(n)
+		}
+		src = src[n:]
+	}
+	if !hasType {
+		return ud, fmt.Errorf("invalid UnixFS Data (no Type)")
+	}
+	if ud.typ == unixfsHAMTShard && ud.fanout < 2 {
+		return ud, fmt.Errorf("invalid UnixFS HAMT shard (fanout %d)", ud.fanout)
+	}
+	return ud, nil
+}
+
+// hamtPadLength returns the length of the bucket prefix on the link Names of
+// a HAMT shard with the given fanout.
+func hamtPadLength(fanout uint64) int {
+	return len(fmt.Sprintf("%X", fanout-1))
+}
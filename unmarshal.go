@@ -1,6 +1,7 @@
 package dagpb
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 
@@ -14,13 +15,51 @@ import (
 // malformed data
 var ErrIntOverflow = fmt.Errorf("protobuf: varint overflow")
 
+// DecodeOptions can be used to customize the behavior of decoding, through
+// its Decode and DecodeBytes methods. The zero value decodes exactly as the
+// package-level Decode and DecodeBytes do.
+type DecodeOptions struct {
+	// LinkPolicy, if set, is checked against the Hash of each link as it is
+	// decoded, and decoding fails with a *LinkPolicyError on the first link
+	// it does not allow.
+	LinkPolicy *LinkPolicy
+
+	// CopyData copies the Data out of the block as it is decoded. Otherwise
+	// the Data of the decoded node is a slice of the block, which saves a copy
+	// but means the block must not be changed or reused while the node is in
+	// use. Names and Hashes are always copied.
+	CopyData bool
+
+	// SkipData leaves the Data out of the decoded node, and out of the parts
+	// passed to a Visitor. See DecodePartial.
+	SkipData bool
+
+	// SkipLinks leaves the Links out of the decoded node, and out of the
+	// parts passed to a Visitor. The skipped links are still checked, as is
+	// LinkPolicy, unless SkipValidation is also set. See DecodePartial.
+	SkipLinks bool
+
+	// SkipValidation, with SkipLinks, skips checking the links beyond finding
+	// where each ends, which is faster where the block is trusted.
+	SkipValidation bool
+}
+
 // Decode provides an IPLD codec decode interface for DAG-PB data. Provide a
 // compatible NodeAssembler and a byte source to unmarshal a DAG-PB IPLD Node.
 // Use the NodeAssembler from the PBNode type for safest construction
 // (Type.PBNode.NewBuilder()). A Map assembler will also work.
 // This function is registered via the go-ipld-prime link loader for multicodec
 // code 0x70 when this package is invoked via init.
+//
+// Where in has a Bytes method, as bytes.Buffer does, the block is decoded
+// from those bytes without reading it, and the Data of the node is a slice of
+// them; see DecodeOptions.CopyData.
 func Decode(na ipld.NodeAssembler, in io.Reader) error {
+	return DecodeOptions{}.Decode(na, in)
+}
+
+// Decode is like the package-level Decode, applying the options.
+func (opts DecodeOptions) Decode(na ipld.NodeAssembler, in io.Reader) error {
 	var src []byte
 	if buf, ok := in.(interface{ Bytes() []byte }); ok {
 		src = buf.Bytes()
@@ -31,23 +70,89 @@ func Decode(na ipld.NodeAssembler, in io.Reader) error {
 			return err
 		}
 	}
-	return DecodeBytes(na, src)
+	return opts.DecodeBytes(na, src)
 }
 
 // DecodeBytes is like Decode, but it uses an input buffer directly.
 // Decode will grab or read all the bytes from an io.Reader anyway, so this can
 // save having to copy the bytes or create a bytes.Buffer.
+//
+// The Data of the node is a slice of src, not a copy, so src must not be
+// changed or reused while the node is in use; see DecodeOptions.CopyData.
 func DecodeBytes(na ipld.NodeAssembler, src []byte) error {
-	remaining := src
+	return DecodeOptions{}.DecodeBytes(na, src)
+}
 
+// DecodeBytes is like the package-level DecodeBytes, applying the options.
+// With SkipLinks, the Links are left out of the map entirely, which the
+// PBNode type does not allow; use DecodePartial to build a PBNode instead.
+func (opts DecodeOptions) DecodeBytes(na ipld.NodeAssembler, src []byte) error {
 	ma, err := na.BeginMap(2)
 	if err != nil {
 		return err
 	}
-	var links ipld.ListAssembler
+	av := &assemblingVisitor{ma: ma, omitLinks: opts.SkipLinks}
+	if opts.SkipData || opts.SkipLinks || opts.CopyData {
+		return opts.visit(src, av)
+	}
+	// The common case, assembling every field, skips the wrapping that visit
+	// does for the options.
+	err = scanNode(src, av.OnData, func(index int, chunk []byte) error {
+		if err := av.OnLinkStart(index); err != nil {
+			return err
+		}
+		if err := visitLink(chunk, av, opts.LinkPolicy, index); err != nil {
+			return err
+		}
+		return av.OnLinkEnd()
+	})
+	if err != nil {
+		return err
+	}
+	return av.OnEnd()
+}
+
+// visit is the parsing loop behind Visit and DecodeBytes.
+func (opts DecodeOptions) visit(src []byte, v Visitor) error {
+	onData := v.OnData
+	switch {
+	case opts.SkipData:
+		onData = func([]byte) error { return nil }
+	case opts.CopyData:
+		onData = func(data []byte) error { return v.OnData(bytes.Clone(data)) }
+	}
+	onLink := func(index int, chunk []byte) error {
+		if err := v.OnLinkStart(index); err != nil {
+			return err
+		}
+		if err := visitLink(chunk, v, opts.LinkPolicy, index); err != nil {
+			return err
+		}
+		return v.OnLinkEnd()
+	}
+	if opts.SkipLinks {
+		onLink = func(index int, chunk []byte) error {
+			if opts.SkipValidation {
+				return nil
+			}
+			return visitLink(chunk, discardVisitor{}, opts.LinkPolicy, index)
+		}
+	}
+	if err := scanNode(src, onData, onLink); err != nil {
+		return err
+	}
+	return v.OnEnd()
+}
+
+// scanNode reads the top level of the block in src, passing the Data to
+// onData and each of the Links, undecoded, to onLink, with its index.
+func scanNode(src []byte, onData func(data []byte) error, onLink func(index int, chunk []byte) error) error {
+	remaining := src
 
 	haveData := false
 	haveLinks := false
+	inLinks := false
+	linkIndex := 0
 	for {
 		if len(remaining) == 0 {
 			break
@@ -79,19 +184,9 @@ func DecodeBytes(na ipld.NodeAssembler, src []byte) error {
 			}
 			remaining = remaining[n:]
 
-			if links != nil {
-				// Links came before Data.
-				// Finish them before we start Data.
-				if err := links.Finish(); err != nil {
-					return err
-				}
-				links = nil
-			}
-
-			if err := ma.AssembleKey().AssignString("Data"); err != nil {
-				return err
-			}
-			if err := ma.AssembleValue().AssignBytes(chunk); err != nil {
+			// Links, if any came before Data, are over.
+			inLinks = false
+			if err := onData(chunk); err != nil {
 				return err
 			}
 			haveData = true
@@ -103,51 +198,107 @@ func DecodeBytes(na ipld.NodeAssembler, src []byte) error {
 			}
 			remaining = remaining[n:]
 
-			if links == nil {
-				if haveLinks {
-					return fmt.Errorf("protobuf: (PBNode) duplicate Links section")
-				}
-
-				// The repeated "Links" part begins.
-				if err := ma.AssembleKey().AssignString("Links"); err != nil {
-					return err
-				}
-				links, err = ma.AssembleValue().BeginList(0)
-				if err != nil {
-					return err
-				}
+			if !inLinks && haveLinks {
+				return fmt.Errorf("protobuf: (PBNode) duplicate Links section")
 			}
+			inLinks = true
 
-			curLink, err := links.AssembleValue().BeginMap(3)
-			if err != nil {
-				return err
-			}
-			if err := unmarshalLink(chunk, curLink); err != nil {
-				return err
-			}
-			if err := curLink.Finish(); err != nil {
+			if err := onLink(linkIndex, chunk); err != nil {
 				return err
 			}
+			linkIndex++
 			haveLinks = true
 
 		default:
 			return fmt.Errorf("protobuf: (PBNode) invalid fieldNumber, expected 1 or 2, got %d", fieldNum)
 		}
 	}
+	return nil
+}
+
+// assemblingVisitor is the Visitor behind DecodeBytes, assembling the parts
+// into the map ma. Where it only assembles a single link, into link, ma is
+// nil. The Links are always assembled, if only as an empty list, unless
+// omitLinks is set.
+type assemblingVisitor struct {
+	ma        ipld.MapAssembler
+	links     ipld.ListAssembler
+	link      ipld.MapAssembler
+	haveLinks bool
+	omitLinks bool
+}
+
+func (av *assemblingVisitor) OnData(data []byte) error {
+	if av.links != nil {
+		// Links came before Data.
+		// Finish them before we start Data.
+		if err := av.links.Finish(); err != nil {
+			return err
+		}
+		av.links = nil
+	}
+	if err := av.ma.AssembleKey().AssignString("Data"); err != nil {
+		return err
+	}
+	return av.ma.AssembleValue().AssignBytes(data)
+}
 
-	if links != nil {
+func (av *assemblingVisitor) OnLinkStart(int) error {
+	if av.links == nil {
+		// The repeated "Links" part begins.
+		if err := av.ma.AssembleKey().AssignString("Links"); err != nil {
+			return err
+		}
+		var err error
+		if av.links, err = av.ma.AssembleValue().BeginList(0); err != nil {
+			return err
+		}
+		av.haveLinks = true
+	}
+	var err error
+	av.link, err = av.links.AssembleValue().BeginMap(3)
+	return err
+}
+
+func (av *assemblingVisitor) OnHash(c cid.Cid) error {
+	if err := av.link.AssembleKey().AssignString("Hash"); err != nil {
+		return err
+	}
+	return av.link.AssembleValue().AssignLink(cidlink.Link{Cid: c})
+}
+
+func (av *assemblingVisitor) OnName(name string) error {
+	if err := av.link.AssembleKey().AssignString("Name"); err != nil {
+		return err
+	}
+	return av.link.AssembleValue().AssignString(name)
+}
+
+func (av *assemblingVisitor) OnTsize(tsize uint64) error {
+	if err := av.link.AssembleKey().AssignString("Tsize"); err != nil {
+		return err
+	}
+	return av.link.AssembleValue().AssignInt(int64(tsize))
+}
+
+func (av *assemblingVisitor) OnLinkEnd() error {
+	return av.link.Finish()
+}
+
+func (av *assemblingVisitor) OnEnd() error {
+	if av.links != nil {
 		// We had some links at the end, so finish them.
-		if err := links.Finish(); err != nil {
+		if err := av.links.Finish(); err != nil {
 			return err
 		}
 
-	} else if !haveLinks {
+	} else if !av.haveLinks && !av.omitLinks {
 		// We didn't have any links.
 		// Since we always want a Links field, add one here.
-		if err := ma.AssembleKey().AssignString("Links"); err != nil {
+		if err := av.ma.AssembleKey().AssignString("Links"); err != nil {
 			return err
 		}
-		links, err := ma.AssembleValue().BeginList(0)
+		links, err := av.ma.AssembleValue().BeginList(0)
 		if err != nil {
 			return err
 		}
@@ -155,112 +306,160 @@ func DecodeBytes(na ipld.NodeAssembler, src []byte) error {
 			return err
 		}
 	}
-	return ma.Finish()
+	return av.ma.Finish()
 }
 
-func unmarshalLink(remaining []byte, ma ipld.MapAssembler) error {
-	haveHash := false
-	haveName := false
-	haveTsize := false
-	for {
-		if len(remaining) == 0 {
-			break
-		}
+// discardVisitor is a Visitor that does nothing, for parts that are only
+// checked.
+type discardVisitor struct{}
+
+func (discardVisitor) OnData([]byte) error   { return nil }
+func (discardVisitor) OnLinkStart(int) error { return nil }
+func (discardVisitor) OnHash(cid.Cid) error  { return nil }
+func (discardVisitor) OnName(string) error   { return nil }
+func (discardVisitor) OnTsize(uint64) error  { return nil }
+func (discardVisitor) OnLinkEnd() error      { return nil }
+func (discardVisitor) OnEnd() error          { return nil }
+
+// unmarshalLink decodes the link at index in the Links into ma, checking its
+// Hash against policy, which may be nil.
+func unmarshalLink(remaining []byte, ma ipld.MapAssembler, policy *LinkPolicy, index int) error {
+	return visitLink(remaining, &assemblingVisitor{link: ma}, policy, index)
+}
 
-		fieldNum, wireType, n := protowire.ConsumeTag(remaining)
-		if n < 0 {
-			return protowire.ParseError(n)
-		}
-		remaining = remaining[n:]
+// decodeLink decodes a single link, as found in the Links.
+func decodeLink(chunk []byte) (_PBLink, error) {
+	builder := Type.PBLink.NewBuilder()
+	ma, err := builder.BeginMap(3)
+	if err != nil {
+		return _PBLink{}, err
+	}
+	if err := unmarshalLink(chunk, ma, nil, 0); err != nil {
+		return _PBLink{}, err
+	}
+	if err := ma.Finish(); err != nil {
+		return _PBLink{}, err
+	}
+	return *builder.Build().(PBLink), nil
+}
 
+// visitLink reads the link at index in the Links, passing its fields to v and
+// checking its Hash against policy, which may be nil.
+func visitLink(remaining []byte, v Visitor, policy *LinkPolicy, index int) error {
+	lr := linkReader{remaining: remaining, policy: policy, index: index}
+	for {
+		fieldNum, hash, name, tsize, err := lr.next()
+		if err != nil || fieldNum == 0 {
+			return err
+		}
 		switch fieldNum {
 		case 1:
-			if haveHash {
-				return fmt.Errorf("protobuf: (PBLink) duplicate Hash section")
-			}
-			if haveName {
-				return fmt.Errorf("protobuf: (PBLink) invalid order, found Name before Hash")
-			}
-			if haveTsize {
-				return fmt.Errorf("protobuf: (PBLink) invalid order, found Tsize before Hash")
-			}
-			if wireType != 2 {
-				return fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Hash", wireType)
-			}
+			err = v.OnHash(hash)
+		case 2:
+			err = v.OnName(string(name))
+		case 3:
+			err = v.OnTsize(tsize)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			chunk, n := protowire.ConsumeBytes(remaining)
-			if n < 0 {
-				return protowire.ParseError(n)
-			}
-			remaining = remaining[n:]
+// linkReader reads the fields of an encoded link in turn, checking that each
+// is valid and in order, and the Hash against policy, which may be nil.
+type linkReader struct {
+	remaining []byte
+	policy    *LinkPolicy
+	index     int
+	haveHash  bool
+	haveName  bool
+	haveTsize bool
+}
 
-			_, c, err := cid.CidFromBytes(chunk)
-			if err != nil {
-				return fmt.Errorf("invalid Hash field found in link, expected CID (%v)", err)
-			}
-			if err := ma.AssembleKey().AssignString("Hash"); err != nil {
-				return err
-			}
-			if err := ma.AssembleValue().AssignLink(cidlink.Link{Cid: c}); err != nil {
-				return err
-			}
-			haveHash = true
+// next reads the next field of the link, returning its number and value, or
+// a number of 0 at the end of the link.
+func (lr *linkReader) next() (fieldNum protowire.Number, hash cid.Cid, name []byte, tsize uint64, err error) {
+	if len(lr.remaining) == 0 {
+		if !lr.haveHash {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("invalid Hash field found in link, expected CID")
+		}
+		return 0, cid.Undef, nil, 0, nil
+	}
 
-		case 2:
-			if haveName {
-				return fmt.Errorf("protobuf: (PBLink) duplicate Name section")
-			}
-			if haveTsize {
-				return fmt.Errorf("protobuf: (PBLink) invalid order, found Tsize before Name")
-			}
-			if wireType != 2 {
-				return fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Name", wireType)
-			}
+	fieldNum, wireType, n := protowire.ConsumeTag(lr.remaining)
+	if n < 0 {
+		return 0, cid.Undef, nil, 0, protowire.ParseError(n)
+	}
+	lr.remaining = lr.remaining[n:]
 
-			chunk, n := protowire.ConsumeBytes(remaining)
-			if n < 0 {
-				return protowire.ParseError(n)
-			}
-			remaining = remaining[n:]
+	switch fieldNum {
+	case 1:
+		if lr.haveHash {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) duplicate Hash section")
+		}
+		if lr.haveName {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) invalid order, found Name before Hash")
+		}
+		if lr.haveTsize {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) invalid order, found Tsize before Hash")
+		}
+		if wireType != 2 {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Hash", wireType)
+		}
 
-			if err := ma.AssembleKey().AssignString("Name"); err != nil {
-				return err
-			}
-			if err := ma.AssembleValue().AssignString(string(chunk)); err != nil {
-				return err
-			}
-			haveName = true
+		chunk, n := protowire.ConsumeBytes(lr.remaining)
+		if n < 0 {
+			return 0, cid.Undef, nil, 0, protowire.ParseError(n)
+		}
+		lr.remaining = lr.remaining[n:]
 
-		case 3:
-			if haveTsize {
-				return fmt.Errorf("protobuf: (PBLink) duplicate Tsize section")
-			}
-			if wireType != 0 {
-				return fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Tsize", wireType)
-			}
+		_, hash, err = cid.CidFromBytes(chunk)
+		if err != nil {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("invalid Hash field found in link, expected CID (%v)", err)
+		}
+		if err := lr.policy.check(hash, lr.index); err != nil {
+			return 0, cid.Undef, nil, 0, err
+		}
+		lr.haveHash = true
 
-			v, n := protowire.ConsumeVarint(remaining)
-			if n < 0 {
-				return protowire.ParseError(n)
-			}
-			remaining = remaining[n:]
+	case 2:
+		if lr.haveName {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) duplicate Name section")
+		}
+		if lr.haveTsize {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) invalid order, found Tsize before Name")
+		}
+		if wireType != 2 {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Name", wireType)
+		}
 
-			if err := ma.AssembleKey().AssignString("Tsize"); err != nil {
-				return err
-			}
-			if err := ma.AssembleValue().AssignInt(int64(v)); err != nil {
-				return err
-			}
-			haveTsize = true
+		chunk, n := protowire.ConsumeBytes(lr.remaining)
+		if n < 0 {
+			return 0, cid.Undef, nil, 0, protowire.ParseError(n)
+		}
+		lr.remaining = lr.remaining[n:]
+		name = chunk
+		lr.haveName = true
 
-		default:
-			return fmt.Errorf("protobuf: (PBLink) invalid fieldNumber, expected 1, 2 or 3, got %d", fieldNum)
+	case 3:
+		if lr.haveTsize {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) duplicate Tsize section")
+		}
+		if wireType != 0 {
+			return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) wrong wireType (%d) for Tsize", wireType)
 		}
-	}
 
-	if !haveHash {
-		return fmt.Errorf("invalid Hash field found in link, expected CID")
-	}
+		v, n := protowire.ConsumeVarint(lr.remaining)
+		if n < 0 {
+			return 0, cid.Undef, nil, 0, protowire.ParseError(n)
+		}
+		lr.remaining = lr.remaining[n:]
+		tsize = v
+		lr.haveTsize = true
 
-	return nil
+	default:
+		return 0, cid.Undef, nil, 0, fmt.Errorf("protobuf: (PBLink) invalid fieldNumber, expected 1, 2 or 3, got %d", fieldNum)
+	}
+	return fieldNum, hash, name, tsize, nil
 }
@@ -0,0 +1,73 @@
+package dagpb
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multihash"
+	mhcore "github.com/multiformats/go-multihash/core"
+)
+
+// ErrWrongCodec is returned by DecodeVerified when the CID is not for DAG-PB
+// (0x70).
+var ErrWrongCodec = fmt.Errorf("dagpb: CID is not for DAG-PB")
+
+// ErrHashMismatch is returned by DecodeVerified when the bytes do not hash
+// to the CID.
+var ErrHashMismatch = fmt.Errorf("dagpb: bytes do not match CID")
+
+// ErrDecodeFailed is returned by DecodeVerified, wrapping the error from
+// DecodeBytes, when bytes that match the CID are not valid DAG-PB.
+var ErrDecodeFailed = fmt.Errorf("dagpb: decode failed")
+
+// DecodeVerified is like DecodeBytes, but first checks that c is a DAG-PB
+// CID and that src hashes to it, so that blocks from untrusted sources can be
+// decoded safely. The three kinds of failure can be told apart with
+// errors.Is, against ErrWrongCodec, ErrHashMismatch and ErrDecodeFailed.
+// Nothing is assembled into na unless the checks pass.
+//
+// Any hash function registered with go-multihash can be checked, which
+// includes the SHA-1, SHA-2, SHA-3, BLAKE2 and BLAKE3 families and identity
+// hashes. A CID with an unregistered hash function fails with an error
+// wrapping multihash.ErrSumNotSupported.
+func DecodeVerified(c cid.Cid, src []byte, na ipld.NodeAssembler) error {
+	if !c.Defined() {
+		return fmt.Errorf("dagpb: undefined CID")
+	}
+	if codec := c.Prefix().Codec; codec != cid.DagProtobuf {
+		return fmt.Errorf("%w (codec 0x%x)", ErrWrongCodec, codec)
+	}
+	if err := verifyHash(c, src); err != nil {
+		return err
+	}
+	if err := DecodeBytes(na, src); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecodeFailed, err)
+	}
+	return nil
+}
+
+// verifyHash checks that src hashes to the multihash of c.
+func verifyHash(c cid.Cid, src []byte) error {
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	if dmh.Code == multihash.IDENTITY {
+		if !bytes.Equal(dmh.Digest, src) {
+			return fmt.Errorf("%w (identity)", ErrHashMismatch)
+		}
+		return nil
+	}
+	hasher, err := mhcore.GetVariableHasher(dmh.Code, dmh.Length)
+	if err != nil {
+		return err
+	}
+	hasher.Write(src)
+	digest := hasher.Sum(make([]byte, 0, 64))
+	if len(digest) < dmh.Length || !bytes.Equal(digest[:dmh.Length], dmh.Digest) {
+		return fmt.Errorf("%w (%s)", ErrHashMismatch, multihash.Codes[dmh.Code])
+	}
+	return nil
+}
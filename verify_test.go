@@ -0,0 +1,82 @@
+package dagpb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestDecodeVerified(t *testing.T) {
+	node, err := NewNodeBuilder().SetData([]byte("some data")).AddLink("a", acid, 1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := mustEncode(t, node)
+
+	for _, mhType := range []uint64{
+		multihash.SHA1, multihash.SHA2_256, multihash.SHA2_512, multihash.SHA3_256,
+		multihash.BLAKE2B_MIN + 31, multihash.BLAKE2S_MIN + 31, multihash.BLAKE3, multihash.IDENTITY,
+	} {
+		t.Run(multihash.Codes[mhType], func(t *testing.T) {
+			c, err := cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: mhType, MhLength: -1}.Sum(enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nb := Type.PBNode.NewBuilder()
+			if err := DecodeVerified(c, enc, nb); err != nil {
+				t.Fatal(err)
+			}
+			if got := mustEncode(t, nb.Build().(PBNode)); string(got) != string(enc) {
+				t.Fatal("decoded node does not round-trip")
+			}
+
+			tampered := append([]byte{}, enc...)
+			tampered[len(tampered)-1] ^= 1
+			if err := DecodeVerified(c, tampered, Type.PBNode.NewBuilder()); !errors.Is(err, ErrHashMismatch) {
+				t.Fatalf("expected ErrHashMismatch, got %v", err)
+			}
+		})
+	}
+
+	// truncated digests and CIDv0
+	for _, prefix := range []cid.Prefix{
+		{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: 20},
+		{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1},
+	} {
+		c, err := prefix.Sum(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := DecodeVerified(c, enc, Type.PBNode.NewBuilder()); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+
+	raw, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeVerified(raw, enc, Type.PBNode.NewBuilder()); !errors.Is(err, ErrWrongCodec) {
+		t.Fatalf("expected ErrWrongCodec, got %v", err)
+	}
+
+	bad := []byte{0x0a} // Data with no length
+	c, err := cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}.Sum(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeVerified(c, bad, Type.PBNode.NewBuilder()); !errors.Is(err, ErrDecodeFailed) || errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("expected ErrDecodeFailed, got %v", err)
+	}
+
+	unsupported, err := cid.V1Builder{Codec: cid.DagProtobuf, MhType: multihash.SHA2_256}.Sum(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mh, _ := multihash.Encode(unsupported.Hash()[2:], 0x9999)
+	if err := DecodeVerified(cid.NewCidV1(cid.DagProtobuf, mh), enc, Type.PBNode.NewBuilder()); !errors.Is(err, multihash.ErrSumNotSupported) {
+		t.Fatalf("expected ErrSumNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package dagpb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrStopVisit can be returned by a Visitor callback to stop Visit early,
+// without error.
+var ErrStopVisit = fmt.Errorf("dagpb: stop visit")
+
+// Visitor receives the parts of a DAG-PB block from Visit, in the order they
+// appear in the block, without building a node. The Links may come before or
+// after the Data, as decoding accepts either. For each link, OnLinkStart is
+// followed by OnHash, then OnName and OnTsize where present, then OnLinkEnd.
+// OnEnd is called once the whole block has been read and found valid.
+//
+// Any error returned by a callback stops Visit, which returns it, except for
+// ErrStopVisit, where Visit returns nil.
+type Visitor interface {
+	// OnData is called with the Data, which is a slice of the block and only
+	// valid for as long as the block is, unless DecodeOptions.CopyData is set.
+	OnData(data []byte) error
+	// OnLinkStart is called at the start of the link at index in the Links.
+	OnLinkStart(index int) error
+	OnHash(c cid.Cid) error
+	OnName(name string) error
+	OnTsize(tsize uint64) error
+	OnLinkEnd() error
+	OnEnd() error
+}
+
+// Visit reads the DAG-PB block in src, passing its parts to v. It validates
+// the block exactly as DecodeBytes does, sharing the same parsing, but an
+// invalid block is only reported once reached, after v has seen the parts
+// before it.
+func Visit(src []byte, v Visitor) error {
+	return DecodeOptions{}.Visit(src, v)
+}
+
+// Visit is like the package-level Visit, applying the options.
+func (opts DecodeOptions) Visit(src []byte, v Visitor) error {
+	if err := opts.visit(src, v); err != nil && !errors.Is(err, ErrStopVisit) {
+		return err
+	}
+	return nil
+}
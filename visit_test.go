@@ -0,0 +1,121 @@
+package dagpb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// recordingVisitor records each callback, and returns stop from the callback
+// named stopAt.
+type recordingVisitor struct {
+	calls  []string
+	stopAt string
+	stop   error
+}
+
+func (rv *recordingVisitor) record(call string, arg any) error {
+	rv.calls = append(rv.calls, fmt.Sprintf("%s(%v)", call, arg))
+	if call == rv.stopAt {
+		return rv.stop
+	}
+	return nil
+}
+
+func (rv *recordingVisitor) OnData(data []byte) error    { return rv.record("OnData", string(data)) }
+func (rv *recordingVisitor) OnLinkStart(index int) error { return rv.record("OnLinkStart", index) }
+func (rv *recordingVisitor) OnHash(c cid.Cid) error      { return rv.record("OnHash", c.Prefix().Codec) }
+func (rv *recordingVisitor) OnName(name string) error    { return rv.record("OnName", name) }
+func (rv *recordingVisitor) OnTsize(tsize uint64) error  { return rv.record("OnTsize", tsize) }
+func (rv *recordingVisitor) OnLinkEnd() error            { return rv.record("OnLinkEnd", "") }
+func (rv *recordingVisitor) OnEnd() error                { return rv.record("OnEnd", "") }
+
+func TestVisit(t *testing.T) {
+	c, _ := cid.Decode("bafkqabiaaebagba")
+	node, err := NewNodeBuilder().SetData([]byte("data")).AddLink("a", c, 3).AddLink("", c, 0).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := mustEncode(t, node)
+
+	rv := &recordingVisitor{}
+	if err := Visit(enc, rv); err != nil {
+		t.Fatal(err)
+	}
+	expected := "OnLinkStart(0) OnHash(85) OnName() OnTsize(0) OnLinkEnd() " +
+		"OnLinkStart(1) OnHash(85) OnName(a) OnTsize(3) OnLinkEnd() OnData(data) OnEnd()"
+	if got := strings.Join(rv.calls, " "); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	// stopping early is not an error, and nothing more is visited
+	rv = &recordingVisitor{stopAt: "OnLinkEnd", stop: ErrStopVisit}
+	if err := Visit(enc, rv); err != nil {
+		t.Fatal(err)
+	}
+	if len(rv.calls) != 5 {
+		t.Fatalf("expected to stop after the first link, got %v", rv.calls)
+	}
+
+	// other errors are returned as they are
+	boom := fmt.Errorf("boom")
+	rv = &recordingVisitor{stopAt: "OnName", stop: boom}
+	if err := Visit(enc, rv); !errors.Is(err, boom) {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+
+	// invalid blocks are reported once reached, as by DecodeBytes
+	rv = &recordingVisitor{}
+	err = Visit(append(enc, 0x0a, 0x00), rv)
+	if err == nil || err.Error() != "protobuf: (PBNode) duplicate Data section" {
+		t.Fatalf("expected a duplicate Data error, got %v", err)
+	}
+	if rv.calls[len(rv.calls)-1] != "OnData(data)" {
+		t.Fatalf("expected the block visited up to the error, got %v", rv.calls)
+	}
+}
+
+// benchmarkLinksBlock encodes a node with 1000 links and a little Data, for
+// measuring the cost of decoding links.
+func benchmarkLinksBlock(b *testing.B) []byte {
+	c, err := cid.Decode("QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39U")
+	if err != nil {
+		b.Fatal(err)
+	}
+	builder := NewNodeBuilder().SetData([]byte{0x08, 0x01})
+	for ii := range 1000 {
+		builder.AddLink(fmt.Sprintf("entry-%04d", ii), c, uint64(ii))
+	}
+	node, err := builder.Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	enc, err := AppendEncode(nil, node)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return enc
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	enc := benchmarkLinksBlock(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := DecodeBytes(Type.PBNode.NewBuilder(), enc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVisit(b *testing.B) {
+	enc := benchmarkLinksBlock(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Visit(enc, discardVisitor{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}